@@ -0,0 +1,229 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/munnerz/kube-acme/pkg/ratelimit"
+	"github.com/namsral/flag"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+var (
+	backoffBase = flag.Duration("backoffBase", time.Minute, "how long to wait before retrying a failed certificate request, doubling after each consecutive failure")
+	backoffMax  = flag.Duration("backoffMax", time.Hour*24, "the maximum time to wait before retrying a failed certificate request")
+)
+
+// backoffError is returned for secrets still inside their backoff window
+type backoffError struct {
+	failures  int
+	nextRetry time.Time
+	lastError string
+}
+
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("backing off after %d failures until %s, last error: %s", e.failures, e.nextRetry.Format(time.RFC3339), e.lastError)
+}
+
+// backoffDelay returns how long to wait after the given number of
+// consecutive failures
+func backoffDelay(failures int) time.Duration {
+	d := *backoffBase
+
+	for i := 1; i < failures && d < *backoffMax; i++ {
+		d *= 2
+	}
+
+	if d > *backoffMax {
+		d = *backoffMax
+	}
+
+	return d
+}
+
+// failureFromSecret returns the failures recorded in the annotations of
+// secret, and false if there are none
+func failureFromSecret(secret *api.Secret) (ratelimit.Failure, bool) {
+	nextRetry, err := time.Parse(time.RFC3339, secret.Annotations["acme-next-retry"])
+
+	if err != nil {
+		return ratelimit.Failure{}, false
+	}
+
+	failures, _ := strconv.Atoi(secret.Annotations["acme-failures"])
+
+	return ratelimit.Failure{
+		Failures:  failures,
+		LastError: secret.Annotations["acme-last-error"],
+		NextRetry: nextRetry,
+	}, true
+}
+
+// checkBackoff returns a *backoffError if the secret name is inside its
+// backoff window, unless secret has the acme-retry-now annotation. Failures
+// are read from the annotations of secret, or from the ledger if the secret
+// does not exist yet.
+func (c *Controller) checkBackoff(name, namespace string, secret *api.Secret) error {
+	var f ratelimit.Failure
+	var ok bool
+
+	if secret != nil {
+		if secret.Annotations["acme-retry-now"] == "true" {
+			return nil
+		}
+
+		f, ok = failureFromSecret(secret)
+	} else {
+		l, err := c.ledger.Load()
+
+		if err != nil {
+			return err
+		}
+
+		f, ok = l.Failures[secretKey(namespace, name)]
+	}
+
+	if !ok || !c.clock.Now().Before(f.NextRetry) {
+		return nil
+	}
+
+	return &backoffError{
+		failures:  f.Failures,
+		nextRetry: f.NextRetry,
+		lastError: f.LastError,
+	}
+}
+
+// nextFailure returns f after another failure with failErr
+func (c *Controller) nextFailure(f ratelimit.Failure, failErr error) ratelimit.Failure {
+	f.Failures++
+	f.LastError = failErr.Error()
+	f.NextRetry = c.clock.Now().Add(backoffDelay(f.Failures)).UTC().Truncate(time.Second)
+
+	return f
+}
+
+// recordFailure records a failed certificate request for the secret name and
+// returns the time of the next attempt. Failures are recorded in annotations
+// on the secret. Secrets are only created once a certificate has been issued,
+// and the lock secrets are deleted when they are released, so failures of
+// secrets that do not exist yet are recorded in the ledger instead.
+func (c *Controller) recordFailure(name, namespace string, failErr error) (time.Time, error) {
+	secret, err := c.secrets.Secrets(namespace).Get(name)
+
+	if err != nil && !kerrors.IsNotFound(err) {
+		return time.Time{}, fmt.Errorf("error getting secret to record failure: %s", err.Error())
+	}
+
+	if err == nil {
+		f, _ := failureFromSecret(secret)
+		f = c.nextFailure(f, failErr)
+
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+
+		secret.Annotations["acme-failures"] = strconv.Itoa(f.Failures)
+		secret.Annotations["acme-last-error"] = f.LastError
+		secret.Annotations["acme-next-retry"] = f.NextRetry.Format(time.RFC3339)
+		// the retry requested with acme-retry-now has been made
+		delete(secret.Annotations, "acme-retry-now")
+
+		if _, err := c.secrets.Secrets(namespace).Update(secret); err != nil {
+			return time.Time{}, fmt.Errorf("error recording failure on secret: %s", err.Error())
+		}
+
+		return f.NextRetry, nil
+	}
+
+	var nextRetry time.Time
+
+	err = c.ledger.Update(func(l *ratelimit.Ledger) {
+		if l.Failures == nil {
+			l.Failures = map[string]ratelimit.Failure{}
+		}
+
+		f := c.nextFailure(l.Failures[secretKey(namespace, name)], failErr)
+		l.Failures[secretKey(namespace, name)] = f
+
+		nextRetry = f.NextRetry
+	})
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error recording failure in ledger: %s", err.Error())
+	}
+
+	return nextRetry, nil
+}
+
+// clearFailures removes the failures recorded for the secret name, from the
+// ledger and from the annotations of the secret
+func (c *Controller) clearFailures(name, namespace string) error {
+	l, err := c.ledger.Load()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := l.Failures[secretKey(namespace, name)]; ok {
+		err := c.ledger.Update(func(l *ratelimit.Ledger) {
+			delete(l.Failures, secretKey(namespace, name))
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	secret, err := c.secrets.Secrets(namespace).Get(name)
+
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := failureFromSecret(secret); !ok {
+		return nil
+	}
+
+	for _, k := range []string{"acme-failures", "acme-last-error", "acme-next-retry"} {
+		delete(secret.Annotations, k)
+	}
+
+	_, err = c.secrets.Secrets(namespace).Update(secret)
+
+	return err
+}
+
+// retryNow returns true if ing has the acme-retry-now annotation, which
+// retries its failed requests straight away
+func retryNow(ing *extensions.Ingress) bool {
+	return ing.Annotations["acme-retry-now"] == "true"
+}
+
+// removeRetryNow removes the acme-retry-now annotation from ing once its
+// secrets have been retried
+func (c *Controller) removeRetryNow(ing *extensions.Ingress) error {
+	cur, err := c.ingresses.Ingress(ing.Namespace).Get(ing.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := cur.Annotations["acme-retry-now"]; !ok {
+		return nil
+	}
+
+	delete(cur.Annotations, "acme-retry-now")
+
+	_, err = c.ingresses.Ingress(ing.Namespace).Update(cur)
+
+	return err
+}
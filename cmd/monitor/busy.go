@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+)
+
+// busySet holds the secrets that are being processed, keyed by their
+// namespace/name. A secret is only processed by one goroutine at a time,
+// without blocking the processing of other secrets while a certificate is
+// requested.
+type busySet struct {
+	sync.Mutex
+	secrets map[string]bool
+}
+
+func newBusySet() *busySet {
+	return &busySet{secrets: map[string]bool{}}
+}
+
+func secretKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// tryLock marks the secret name as being processed, returning false if it
+// already is
+func (b *busySet) tryLock(namespace, name string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.secrets[secretKey(namespace, name)] {
+		return false
+	}
+
+	b.secrets[secretKey(namespace, name)] = true
+	return true
+}
+
+// unlock marks the secret name as no longer being processed
+func (b *busySet) unlock(namespace, name string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.secrets, secretKey(namespace, name))
+}
@@ -0,0 +1,385 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hashicorp/go-multierror"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/locking"
+	"github.com/munnerz/kube-acme/pkg/monitor"
+	"github.com/munnerz/kube-acme/pkg/ratelimit"
+	"github.com/munnerz/kube-acme/pkg/watcher"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ocsp"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// Controller requests certificates for acme-tls ingresses and manages the
+// secrets they are stored in. All of its dependencies are passed in to
+// NewController, so that it can be run against fake clients and a fake clock.
+type Controller struct {
+	secrets   client.SecretsNamespacer
+	ingresses client.IngressNamespacer
+	issuers   map[string]acmeimpl.Interface
+	locks     *locking.Locking
+	ledger    ratelimit.Store
+	limits    ratelimit.Limits
+	clock     util.Clock
+	pending   *pendingSet
+
+	// busy holds the secrets currently being processed, as ingresses are
+	// processed from the informer as well as the OCSP and renewal checks
+	busy *busySet
+
+	// lookupHost resolves host names to addresses
+	lookupHost func(string) ([]string, error)
+	// getOCSP fetches the OCSP response for a PEM encoded certificate bundle
+	getOCSP func([]byte) ([]byte, *ocsp.Response, error)
+}
+
+// NewController returns a Controller that stores certificates with secrets,
+// finds the ingresses using them with ingresses, requests them from issuers
+// within limits recorded in ledger and takes locks on hosts with lockProvider
+func NewController(secrets client.SecretsNamespacer, ingresses client.IngressNamespacer, issuers map[string]acmeimpl.Interface, lockProvider locking.Provider, ledger ratelimit.Store, limits ratelimit.Limits, clock util.Clock) (*Controller, error) {
+	if err := validateFailover(issuers); err != nil {
+		return nil, err
+	}
+
+	locks, err := locking.New(lockProvider)
+
+	if err != nil {
+		return nil, fmt.Errorf("error initialisng locker: %s", err.Error())
+	}
+
+	return &Controller{
+		secrets:    secrets,
+		ingresses:  ingresses,
+		issuers:    issuers,
+		locks:      locks,
+		ledger:     ledger,
+		limits:     limits,
+		clock:      clock,
+		pending:    newPendingSet(),
+		busy:       newBusySet(),
+		lookupHost: net.LookupHost,
+		getOCSP:    acme.GetOCSPForCert,
+	}, nil
+}
+
+// ChangeFuncs returns the functions to handle ingress changes with
+func (c *Controller) ChangeFuncs() watcher.ChangeFuncs {
+	return watcher.ChangeFuncs{
+		AddFunc:    c.addIngFunc,
+		UpdateFunc: c.updateIngFunc,
+		DeleteFunc: c.deleteIngFunc,
+	}
+}
+
+func (c *Controller) acquireAllLocks(names []string) ([]locking.Interface, error) {
+	locks := make([]locking.Interface, len(names))
+
+	for i, name := range names {
+		lock, err := locking.NewKubeLock(createSecretLock(name, "acme", c.clock.Now()))
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating lock with name '%s': %s", name, err.Error())
+		}
+
+		locks[i] = lock
+	}
+
+	locks, errs := c.locks.LockAll(locks...)
+
+	if len(errs) > 0 {
+		return nil, errors.New(multierror.ListFormatFunc(errs))
+	}
+
+	return locks, nil
+}
+
+func (c *Controller) getCertificateRequest(name, namespace string, hosts []string, policy keyPolicy, csr acmeimpl.CSROptions) (*acmeimpl.CertificateRequest, bool, error) {
+	existingSecret, err := c.secrets.Secrets(namespace).Get(name)
+
+	cr := &acmeimpl.CertificateRequest{
+		Hosts:   hosts,
+		KeyType: policy.keyType,
+		CSR:     csr,
+	}
+
+	if err != nil {
+		if err := c.checkBackoff(name, namespace, nil); err != nil {
+			return nil, false, err
+		}
+
+		return cr, false, nil
+	}
+
+	if !isAcmeManaged(existingSecret) {
+		return nil, true, fmt.Errorf("secret '%s' already exists and is not acme managed. skipping", name)
+	}
+
+	if err := c.checkBackoff(name, namespace, existingSecret); err != nil {
+		return nil, true, err
+	}
+
+	now := c.clock.Now()
+
+	tlsSecret, err := monitor.TLSSecretFromSecret(existingSecret)
+
+	if err != nil {
+		return cr, true, nil
+	}
+
+	expiry, err := tlsSecret.Expiry()
+
+	if err != nil {
+		return cr, true, nil
+	}
+
+	privKey, err := acmeimpl.ParsePEMPrivateKey(tlsSecret.PrivateKey())
+
+	if err != nil {
+		return cr, true, nil
+	}
+
+	// secrets from before key creation times were recorded have a key at
+	// least as old as their certificate
+	keyCreated := tlsSecret.KeyCreated
+	if keyCreated.IsZero() {
+		if cert, err := tlsSecret.X509Certificate(); err == nil {
+			keyCreated = cert.NotBefore
+		}
+	}
+
+	keyExpired := policy.maxAge > 0 && !keyCreated.IsZero() && now.Sub(keyCreated) > policy.maxAge
+	keyTypeChanged := len(policy.keyType) > 0 && acmeimpl.KeyTypeOf(privKey) != policy.keyType
+	_, revoked := existingSecret.Annotations["acme-revoked"]
+
+	var certHosts []string
+	mustStapleChanged := false
+	if cert, err := tlsSecret.X509Certificate(); err == nil {
+		certHosts = cert.DNSNames
+		mustStapleChanged = acmeimpl.HasMustStaple(cert) != csr.MustStaple
+	}
+
+	hostsChanged := certHosts != nil && !sameHosts(certHosts, hosts)
+
+	if hostsChanged {
+		glog.Infof("[%s] certificate is for hosts %s but %s are requested, reissuing it", name, certHosts, hosts)
+	} else if revoked {
+		glog.Infof("[%s] certificate has been revoked, renewing it with a new private key", name)
+	} else if keyExpired {
+		glog.Infof("[%s] private key was created %s and is older than %s, rotating it", name, keyCreated, policy.maxAge)
+	} else if keyTypeChanged {
+		glog.Infof("[%s] private key is not of type %s, renewing it with a new private key", name, policy.keyType)
+	} else if mustStapleChanged {
+		glog.Infof("[%s] certificate does not match acme-must-staple %t, reissuing it", name, csr.MustStaple)
+	} else if now.Add(*renewThreshold).Before(expiry) {
+		return nil, true, fmt.Errorf("secret '%s' already exists and is valid until %s", name, expiry)
+	}
+
+	cr = &acmeimpl.CertificateRequest{
+		Hosts:            hosts,
+		IsRenewal:        !hostsChanged,
+		ExistingResource: tlsSecret.CertificateResource,
+		PrivateKey:       privKey,
+		KeyCreated:       keyCreated,
+		KeyType:          policy.keyType,
+		CSR:              csr,
+	}
+
+	if policy.rotate || keyExpired || keyTypeChanged || revoked {
+		cr.PrivateKey = nil
+	}
+
+	return cr, true, nil
+}
+
+func (c *Controller) addIngFunc(obj interface{}) {
+	if ing, ok := obj.(*extensions.Ingress); ok {
+		if val, ok := ing.Labels["acme-tls"]; !ok || val != "true" {
+			// only run on ingresses with acme-tls true
+			return
+		}
+
+		deferred := map[string]string{}
+		defer c.pending.set(ing, deferred)
+
+		for _, t := range ing.Spec.TLS {
+			c.syncTLS(ing, t, deferred)
+		}
+
+		if retryNow(ing) {
+			if err := c.removeRetryNow(ing); err != nil {
+				glog.Errorf("[%s] error removing acme-retry-now annotation: %s", ing.Name, err.Error())
+			}
+		}
+	} else {
+		glog.Errorf("Expected object of type Ingress")
+	}
+}
+
+// syncTLS requests a certificate for t of ing if it needs one and saves it,
+// adding t to deferred if it should be retried on the next resync
+func (c *Controller) syncTLS(ing *extensions.Ingress, t extensions.IngressTLS, deferred map[string]string) {
+	// reissueRevoked and checkAllIngresses process ingresses from their own
+	// goroutines, alongside the informer
+	if !c.busy.tryLock(ing.Namespace, t.SecretName) {
+		c.pending.deferSecret(ing, deferred, t, "secret is already being processed")
+		return
+	}
+	defer c.busy.unlock(ing.Namespace, t.SecretName)
+
+	if retryNow(ing) {
+		if err := c.clearFailures(t.SecretName, ing.Namespace); err != nil {
+			glog.Errorf("[%s] error clearing recorded failures: %s", t.SecretName, err.Error())
+		}
+	}
+
+	policy, err := keyPolicyForIngress(ing)
+	if err != nil {
+		glog.Errorf("[%s] not requesting certificate for hosts %s: %s", t.SecretName, t.Hosts, err.Error())
+		return
+	}
+
+	csr, err := csrOptionsForIngress(ing)
+	if err != nil {
+		glog.Errorf("[%s] not requesting certificate for hosts %s: %s", t.SecretName, t.Hosts, err.Error())
+		return
+	}
+
+	certRequest, secretExists, err := c.getCertificateRequest(t.SecretName, ing.Namespace, t.Hosts, policy, csr)
+	if _, ok := err.(*backoffError); ok {
+		c.pending.deferSecret(ing, deferred, t, err.Error())
+		return
+	}
+	if err != nil {
+		glog.Errorf("[%s] not requesting certificate for hosts %s: %s", t.SecretName, t.Hosts, err.Error())
+		return
+	}
+
+	if err := setChallengeOptions(certRequest, ing); err != nil {
+		glog.Errorf("[%s] not requesting certificate for hosts %s: %s", t.SecretName, t.Hosts, err.Error())
+		return
+	}
+
+	if err := c.checkDNS(certRequest, ing); err != nil {
+		c.pending.deferSecret(ing, deferred, t, err.Error())
+		return
+	}
+
+	locks, err := c.acquireAllLocks(t.Hosts)
+
+	if err != nil {
+		glog.Errorf("[%s] failed to acquire all locks for ingress: %s", t.SecretName, err.Error())
+		return
+	}
+
+	defer func() {
+		_, errs := c.locks.UnlockAll(locks...)
+		for _, err := range errs {
+			glog.Errorf("[%s] error releasing lock: %s", t.SecretName, err.Error())
+		}
+	}()
+
+	glog.Errorf("[%s] acquired all locks for resource: %s", t.SecretName, ing.Name)
+
+	certs, issuer, err := c.obtainCertificate(ing, certRequest)
+
+	if _, ok := err.(*ratelimit.LimitError); ok {
+		c.pending.deferSecret(ing, deferred, t, err.Error())
+		return
+	}
+
+	if err != nil {
+		glog.Errorf("[%s] failed to obtain certificate for hosts '%s': %s", t.SecretName, t.Hosts, err.Error())
+
+		nextRetry, err := c.recordFailure(t.SecretName, ing.Namespace, err)
+		if err != nil {
+			glog.Errorf("[%s] %s", t.SecretName, err.Error())
+		} else {
+			deferred[t.SecretName] = fmt.Sprintf("backing off until %s", nextRetry)
+		}
+		return
+	}
+
+	keyCreated := certRequest.KeyCreated
+	if certRequest.PrivateKey == nil {
+		keyCreated = c.clock.Now()
+	}
+
+	tlsSecret := monitor.DefaultTLSSecret{
+		Name:                t.SecretName,
+		Namespace:           ing.Namespace,
+		CertificateResource: *certs,
+		KeyCreated:          keyCreated,
+		Issuer:              issuer,
+		Directory:           c.issuers[issuer].Server(),
+	}
+
+	if *ocspInterval != 0 {
+		if staple, _, err := c.fetchOCSPStaple(certs.Certificate); err != nil {
+			glog.Errorf("[%s] %s", t.SecretName, err.Error())
+		} else {
+			tlsSecret.OCSPStaple = staple
+		}
+	}
+
+	secret, err := tlsSecret.Secret()
+
+	if err != nil {
+		glog.Errorf("[%s] failed to create ingress secret: %s", tlsSecret.Name, err.Error())
+		return
+	}
+
+	if secretExists {
+		secret, err = c.secrets.Secrets(secret.Namespace).Update(secret)
+	} else {
+		secret, err = c.secrets.Secrets(secret.Namespace).Create(secret)
+	}
+
+	if err != nil {
+		glog.Errorf("[%s] error saving certificate to kubernetes: %s", t.SecretName, err)
+		return
+	}
+
+	glog.Errorf("[%s] Successfully saved secret", secret.Name)
+
+	if err := c.clearFailures(t.SecretName, ing.Namespace); err != nil {
+		glog.Errorf("[%s] error clearing recorded failures: %s", t.SecretName, err.Error())
+	}
+}
+
+// updateIngFunc processes ing again if it changed in a way that may affect
+// its certificates, or if it has deferred requests to retry
+func (c *Controller) updateIngFunc(old, cur interface{}) {
+	oldIng, ok := old.(*extensions.Ingress)
+	ing, curOk := cur.(*extensions.Ingress)
+
+	if !ok || !curOk {
+		glog.Errorf("Expected object of type Ingress")
+		return
+	}
+
+	if change := watcher.ClassifyIngressChange(oldIng, ing); change.Relevant() {
+		glog.Infof("Ingress %v changed: %s", ing.Name, change)
+		c.addIngFunc(ing)
+	} else if c.pending.has(ing) {
+		c.addIngFunc(ing)
+	}
+}
+
+// after calls f after d has passed on the controllers clock
+func (c *Controller) after(d time.Duration, f func()) {
+	go func() {
+		<-c.clock.After(d)
+		f()
+	}()
+}
@@ -0,0 +1,579 @@
+package monitor
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/kubetest"
+	"github.com/munnerz/kube-acme/pkg/locking"
+	"github.com/munnerz/kube-acme/pkg/monitor"
+	"github.com/munnerz/kube-acme/pkg/ratelimit"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ocsp"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util"
+)
+
+// fakeIssuer issues self signed certificates valid for 90 days from the
+// time on clock, or fails every request with err
+type fakeIssuer struct {
+	clock util.Clock
+
+	lock     sync.Mutex
+	requests []*acmeimpl.CertificateRequest
+	err      error
+}
+
+func (f *fakeIssuer) Perform(cr *acmeimpl.CertificateRequest) (*acme.CertificateResource, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.requests = append(f.requests, cr)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	key := cr.PrivateKey
+
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	now := f.clock.Now()
+
+	return selfSignedResource(cr.Hosts, key, now, now.Add(time.Hour*24*90))
+}
+
+func (f *fakeIssuer) RevokeCertificate(certPEM []byte) error {
+	return nil
+}
+
+func (f *fakeIssuer) Server() string {
+	return "https://acme.example.com/directory"
+}
+
+func (f *fakeIssuer) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return len(f.requests)
+}
+
+// fakeLockProvider grants every lock
+type fakeLockProvider struct{}
+
+func (fakeLockProvider) Lock(lock locking.Interface) (locking.Interface, error) {
+	return lock, nil
+}
+
+func (fakeLockProvider) Unlock(lock locking.Interface) (locking.Interface, error) {
+	return lock, nil
+}
+
+// memoryLedger is a ratelimit.Store that keeps the ledger in memory
+type memoryLedger struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (m *memoryLedger) Load() (*ratelimit.Ledger, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.load()
+}
+
+func (m *memoryLedger) load() (*ratelimit.Ledger, error) {
+	l := &ratelimit.Ledger{}
+
+	if m.data == nil {
+		return l, nil
+	}
+
+	return l, json.Unmarshal(m.data, l)
+}
+
+func (m *memoryLedger) Update(fn func(*ratelimit.Ledger)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	l, err := m.load()
+
+	if err != nil {
+		return err
+	}
+
+	fn(l)
+
+	m.data, err = json.Marshal(l)
+
+	return err
+}
+
+var testNow = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+
+type testController struct {
+	*Controller
+
+	secrets   *kubetest.Secrets
+	ingresses *kubetest.Ingresses
+	issuer    *fakeIssuer
+	clock     *util.FakeClock
+}
+
+func newTestController(t *testing.T, secrets ...*api.Secret) *testController {
+	clock := util.NewFakeClock(testNow)
+	issuer := &fakeIssuer{clock: clock}
+	ss := kubetest.NewSecrets(secrets...)
+	is := kubetest.NewIngresses()
+
+	c, err := NewController(ss, is, map[string]acmeimpl.Interface{"default": issuer}, fakeLockProvider{}, &memoryLedger{}, rateLimits(), clock)
+
+	if err != nil {
+		t.Fatalf("error creating controller: %s", err.Error())
+	}
+
+	c.getOCSP = func([]byte) ([]byte, *ocsp.Response, error) {
+		return nil, nil, errors.New("no OCSP responder in tests")
+	}
+
+	return &testController{Controller: c, secrets: ss, ingresses: is, issuer: issuer, clock: clock}
+}
+
+func selfSignedResource(hosts []string, key crypto.PrivateKey, notBefore, notAfter time.Time, exts ...pkix.Extension) (*acme.CertificateResource, error) {
+	signer := key.(crypto.Signer)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: hosts[0]},
+		DNSNames:        hosts,
+		NotBefore:       notBefore,
+		NotAfter:        notAfter,
+		ExtraExtensions: exts,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := acmeimpl.EncodePEMPrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &acme.CertificateResource{
+		Domain:      hosts[0],
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  keyPEM,
+	}, nil
+}
+
+// tlsSecret returns an acme managed secret holding a certificate for hosts
+// that expires after validFor, with a key created keyAge ago and any extra
+// certificate extensions in exts
+func tlsSecret(t *testing.T, name string, hosts []string, validFor, keyAge time.Duration, exts ...pkix.Extension) *api.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	res, err := selfSignedResource(hosts, key, testNow.Add(-time.Hour), testNow.Add(validFor), exts...)
+
+	if err != nil {
+		t.Fatalf("error creating certificate: %s", err.Error())
+	}
+
+	ts := monitor.DefaultTLSSecret{
+		Name:                name,
+		Namespace:           "default",
+		CertificateResource: *res,
+		KeyCreated:          testNow.Add(-keyAge),
+	}
+
+	secret, err := ts.Secret()
+
+	if err != nil {
+		t.Fatalf("error creating secret: %s", err.Error())
+	}
+
+	return secret
+}
+
+func TestGetCertificateRequest(t *testing.T) {
+	hosts := []string{"example.com"}
+	day := time.Hour * 24
+
+	revoked := tlsSecret(t, "example-tls", hosts, day*60, day)
+	revoked.Annotations["acme-revoked"] = "true"
+
+	unmanaged := tlsSecret(t, "example-tls", hosts, day*60, day)
+
+	// a TLS feature extension listing status_request
+	mustStaple := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}, Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05}}
+	delete(unmanaged.Labels, "acme-managed")
+
+	tests := []struct {
+		name   string
+		secret *api.Secret
+		hosts  []string
+		policy keyPolicy
+		csr    acmeimpl.CSROptions
+
+		exists  bool
+		err     string
+		renewal bool
+		newKey  bool
+	}{
+		{name: "missing", hosts: hosts, newKey: true},
+		{name: "not acme managed", secret: unmanaged, hosts: hosts, exists: true, err: "is not acme managed"},
+		{name: "valid", secret: tlsSecret(t, "example-tls", hosts, day*60, day), hosts: hosts, exists: true, err: "is valid until"},
+		{name: "expiring", secret: tlsSecret(t, "example-tls", hosts, day*10, day*80), hosts: hosts, exists: true, renewal: true},
+		{name: "rotated key", secret: tlsSecret(t, "example-tls", hosts, day*10, day*80), hosts: hosts, policy: keyPolicy{rotate: true}, exists: true, renewal: true, newKey: true},
+		{name: "key too old", secret: tlsSecret(t, "example-tls", hosts, day*60, day*40), hosts: hosts, policy: keyPolicy{maxAge: day * 30}, exists: true, renewal: true, newKey: true},
+		{name: "same key type", secret: tlsSecret(t, "example-tls", hosts, day*60, day), hosts: hosts, policy: keyPolicy{keyType: acme.EC256}, exists: true, err: "is valid until"},
+		{name: "key type changed", secret: tlsSecret(t, "example-tls", hosts, day*60, day), hosts: hosts, policy: keyPolicy{keyType: acme.RSA2048}, exists: true, renewal: true, newKey: true},
+		{name: "must staple added", secret: tlsSecret(t, "example-tls", hosts, day*60, day), hosts: hosts, csr: acmeimpl.CSROptions{MustStaple: true}, exists: true, renewal: true},
+		{name: "must staple removed", secret: tlsSecret(t, "example-tls", hosts, day*60, day, mustStaple), hosts: hosts, exists: true, renewal: true},
+		{name: "must staple unchanged", secret: tlsSecret(t, "example-tls", hosts, day*60, day, mustStaple), hosts: hosts, csr: acmeimpl.CSROptions{MustStaple: true}, exists: true, err: "is valid until"},
+		{name: "revoked", secret: revoked, hosts: hosts, exists: true, renewal: true, newKey: true},
+		{name: "hosts changed", secret: tlsSecret(t, "example-tls", hosts, day*60, day), hosts: []string{"example.com", "www.example.com"}, exists: true},
+	}
+
+	for _, test := range tests {
+		var secrets []*api.Secret
+		if test.secret != nil {
+			secrets = append(secrets, test.secret)
+		}
+
+		c := newTestController(t, secrets...)
+
+		cr, exists, err := c.getCertificateRequest("example-tls", "default", test.hosts, test.policy, test.csr)
+
+		if exists != test.exists {
+			t.Errorf("%s: expected exists %t, got %t", test.name, test.exists, exists)
+		}
+
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing '%s', got: %v", test.name, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		if !sameHosts(cr.Hosts, test.hosts) {
+			t.Errorf("%s: expected request for %s, got %s", test.name, test.hosts, cr.Hosts)
+		}
+
+		if cr.IsRenewal != test.renewal {
+			t.Errorf("%s: expected renewal %t, got %t", test.name, test.renewal, cr.IsRenewal)
+		}
+
+		if newKey := cr.PrivateKey == nil; newKey != test.newKey {
+			t.Errorf("%s: expected new key %t, got %t", test.name, test.newKey, newKey)
+		}
+
+		if cr.CSR.MustStaple != test.csr.MustStaple {
+			t.Errorf("%s: expected must staple %t, got %t", test.name, test.csr.MustStaple, cr.CSR.MustStaple)
+		}
+
+		if cr.KeyType != test.policy.keyType {
+			t.Errorf("%s: expected key type '%s', got '%s'", test.name, test.policy.keyType, cr.KeyType)
+		}
+	}
+}
+
+func TestGetCertificateRequestBackoff(t *testing.T) {
+	c := newTestController(t)
+	hosts := []string{"example.com"}
+
+	nextRetry, err := c.recordFailure("example-tls", "default", errors.New("validation failed"))
+
+	if err != nil {
+		t.Fatalf("error recording failure: %s", err.Error())
+	}
+
+	if !nextRetry.Equal(testNow.Add(*backoffBase)) {
+		t.Errorf("expected next retry at %s, got %s", testNow.Add(*backoffBase), nextRetry)
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err == nil {
+		t.Errorf("expected no secret to be created for a failed request")
+	}
+
+	if _, _, err := c.getCertificateRequest("example-tls", "default", hosts, keyPolicy{}, acmeimpl.CSROptions{}); err == nil {
+		t.Errorf("expected a backoff error inside the backoff window")
+	} else if _, ok := err.(*backoffError); !ok {
+		t.Errorf("expected a backoff error, got: %s", err.Error())
+	}
+
+	c.clock.Step(*backoffBase)
+
+	if _, _, err := c.getCertificateRequest("example-tls", "default", hosts, keyPolicy{}, acmeimpl.CSROptions{}); err != nil {
+		t.Errorf("expected no error once the backoff window has passed, got: %s", err.Error())
+	}
+
+	// a second failure doubles the delay
+	if nextRetry, _ = c.recordFailure("example-tls", "default", errors.New("validation failed")); !nextRetry.Equal(c.clock.Now().Add(*backoffBase * 2)) {
+		t.Errorf("expected next retry at %s, got %s", c.clock.Now().Add(*backoffBase*2), nextRetry)
+	}
+
+	// acme-retry-now on an existing secret skips the backoff
+	secret := tlsSecret(t, "example-tls", hosts, time.Hour, time.Hour)
+	secret.Annotations["acme-retry-now"] = "true"
+
+	if _, err := c.secrets.Secrets("default").Create(secret); err != nil {
+		t.Fatalf("error creating secret: %s", err.Error())
+	}
+
+	if _, _, err := c.getCertificateRequest("example-tls", "default", hosts, keyPolicy{}, acmeimpl.CSROptions{}); err != nil {
+		t.Errorf("expected acme-retry-now to skip the backoff, got: %s", err.Error())
+	}
+}
+
+func TestRecordFailureOnExistingSecret(t *testing.T) {
+	hosts := []string{"example.com"}
+	c := newTestController(t, tlsSecret(t, "example-tls", hosts, time.Hour, time.Hour))
+
+	if _, err := c.recordFailure("example-tls", "default", errors.New("validation failed")); err != nil {
+		t.Fatalf("error recording failure: %s", err.Error())
+	}
+
+	secret, err := c.secrets.Secrets("default").Get("example-tls")
+
+	if err != nil {
+		t.Fatalf("error getting secret: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"acme-failures":   "1",
+		"acme-last-error": "validation failed",
+		"acme-next-retry": testNow.Add(*backoffBase).Format(time.RFC3339),
+	}
+
+	for k, v := range expected {
+		if secret.Annotations[k] != v {
+			t.Errorf("expected annotation %s to be '%s', got '%s'", k, v, secret.Annotations[k])
+		}
+	}
+
+	if l, _ := c.ledger.Load(); len(l.Failures) != 0 {
+		t.Errorf("expected no failures in the ledger for an existing secret, got %v", l.Failures)
+	}
+
+	if _, _, err := c.getCertificateRequest("example-tls", "default", hosts, keyPolicy{}, acmeimpl.CSROptions{}); err == nil {
+		t.Errorf("expected a backoff error inside the backoff window")
+	} else if _, ok := err.(*backoffError); !ok {
+		t.Errorf("expected a backoff error, got: %s", err.Error())
+	}
+}
+
+func TestIngressRetryNow(t *testing.T) {
+	c := newTestController(t)
+	c.issuer.err = errors.New("validation failed")
+
+	ing, err := c.ingresses.Ingress("default").Create(testIngress("example.com"))
+
+	if err != nil {
+		t.Fatalf("error creating ingress: %s", err.Error())
+	}
+
+	c.addIngFunc(ing)
+
+	if !c.pending.has(ing) {
+		t.Fatalf("expected the failed ingress to be pending")
+	}
+
+	c.issuer.err = nil
+
+	cur, _ := c.ingresses.Ingress("default").Get("example")
+	cur.Annotations["acme-retry-now"] = "true"
+
+	if cur, err = c.ingresses.Ingress("default").Update(cur); err != nil {
+		t.Fatalf("error updating ingress: %s", err.Error())
+	}
+
+	c.updateIngFunc(ing, cur)
+
+	if n := c.issuer.count(); n != 2 {
+		t.Fatalf("expected acme-retry-now on the ingress to skip the backoff, got %d requests", n)
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err != nil {
+		t.Errorf("expected the certificate to be saved: %s", err.Error())
+	}
+
+	if cur, _ = c.ingresses.Ingress("default").Get("example"); cur.Annotations["acme-retry-now"] != "" {
+		t.Errorf("expected acme-retry-now to be removed from the ingress")
+	}
+}
+
+func testIngress(hosts ...string) *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "example",
+			Namespace:   "default",
+			Labels:      map[string]string{"acme-tls": "true"},
+			Annotations: map[string]string{"acme-key-type": string(acme.EC256)},
+		},
+		Spec: extensions.IngressSpec{
+			TLS: []extensions.IngressTLS{
+				{Hosts: hosts, SecretName: "example-tls"},
+			},
+		},
+	}
+}
+
+func TestUpdateIngFunc(t *testing.T) {
+	c := newTestController(t)
+
+	old := testIngress("example.com")
+	cur := testIngress("example.com")
+	cur.Annotations["unrelated"] = "true"
+
+	c.updateIngFunc(old, cur)
+
+	if n := c.issuer.count(); n != 0 {
+		t.Errorf("expected an irrelevant change to be skipped, got %d requests", n)
+	}
+
+	old, cur = cur, testIngress("example.com", "www.example.com")
+	c.updateIngFunc(old, cur)
+
+	if n := c.issuer.count(); n != 1 {
+		t.Fatalf("expected a change of hosts to request a certificate, got %d requests", n)
+	}
+
+	secret, err := c.secrets.Secrets("default").Get("example-tls")
+
+	if err != nil {
+		t.Fatalf("expected the certificate to be saved: %s", err.Error())
+	}
+
+	ts, err := monitor.TLSSecretFromSecret(secret)
+
+	if err != nil {
+		t.Fatalf("error reading saved secret: %s", err.Error())
+	}
+
+	if cert, err := ts.X509Certificate(); err != nil || !sameHosts(cert.DNSNames, cur.Spec.TLS[0].Hosts) {
+		t.Errorf("expected a certificate for %s to be saved", cur.Spec.TLS[0].Hosts)
+	}
+}
+
+func TestUpdateIngFuncRetriesPending(t *testing.T) {
+	c := newTestController(t)
+	c.issuer.err = errors.New("validation failed")
+
+	old, cur := testIngress("example.com"), testIngress("example.com", "www.example.com")
+	c.updateIngFunc(old, cur)
+
+	if n := c.issuer.count(); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+
+	if !c.pending.has(cur) {
+		t.Fatalf("expected the failed ingress to be pending")
+	}
+
+	// resyncs inside the backoff window do not make requests
+	c.updateIngFunc(cur, cur)
+
+	if n := c.issuer.count(); n != 1 {
+		t.Errorf("expected no request inside the backoff window, got %d", n)
+	}
+
+	c.issuer.err = nil
+	c.clock.Step(*backoffBase)
+	c.updateIngFunc(cur, cur)
+
+	if n := c.issuer.count(); n != 2 {
+		t.Fatalf("expected the pending ingress to be retried on resync, got %d requests", n)
+	}
+
+	if c.pending.has(cur) {
+		t.Errorf("expected the ingress to no longer be pending")
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err != nil {
+		t.Errorf("expected the certificate to be saved: %s", err.Error())
+	}
+
+	l, _ := c.ledger.Load()
+
+	if _, ok := l.Failures[secretKey("default", "example-tls")]; ok {
+		t.Errorf("expected recorded failures to be cleared after a successful request")
+	}
+
+	// the ingress is no longer pending, so resyncs are skipped
+	c.updateIngFunc(cur, cur)
+
+	if n := c.issuer.count(); n != 2 {
+		t.Errorf("expected resyncs to be skipped once the certificate is saved, got %d requests", n)
+	}
+}
+
+func TestSyncTLSBusySecret(t *testing.T) {
+	c := newTestController(t)
+
+	// another goroutine is processing the secret
+	if !c.busy.tryLock("default", "example-tls") {
+		t.Fatalf("expected to mark the secret as busy")
+	}
+
+	other := testIngress("other.example.com")
+	other.Name = "other"
+	other.Spec.TLS[0].SecretName = "other-tls"
+
+	ing := testIngress("example.com")
+
+	c.addIngFunc(ing)
+	c.addIngFunc(other)
+
+	if n := c.issuer.count(); n != 1 {
+		t.Fatalf("expected only the secret that is not busy to be requested, got %d requests", n)
+	}
+
+	if !c.pending.has(ing) {
+		t.Errorf("expected the ingress with the busy secret to be pending")
+	}
+
+	c.busy.unlock("default", "example-tls")
+	c.updateIngFunc(ing, ing)
+
+	if n := c.issuer.count(); n != 2 {
+		t.Errorf("expected the pending ingress to be processed once the secret is no longer busy, got %d requests", n)
+	}
+
+	if c.pending.has(ing) {
+		t.Errorf("expected the ingress to no longer be pending")
+	}
+}
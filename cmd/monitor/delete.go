@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/locking"
+	"github.com/munnerz/kube-acme/pkg/monitor"
+	"github.com/namsral/flag"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+)
+
+const (
+	onDeleteKeep   = "keep"
+	onDeleteDelete = "delete"
+	onDeleteRevoke = "revoke"
+
+	// busyRetryDelay is how long to wait before cleaning up a secret that
+	// was being processed
+	busyRetryDelay = time.Minute
+)
+
+var (
+	onDelete          = flag.String("onDelete", onDeleteKeep, "what to do with the secrets of a deleted ingress: 'keep' them, 'delete' them, or 'revoke' the certificate and delete them")
+	deleteGracePeriod = flag.Duration("deleteGracePeriod", time.Minute*5, "how long to wait after an ingress is deleted before handling its secrets, in case it is recreated")
+)
+
+func validateOnDelete() error {
+	switch *onDelete {
+	case onDeleteKeep, onDeleteDelete, onDeleteRevoke:
+		return nil
+	}
+	return fmt.Errorf("invalid onDelete value '%s'", *onDelete)
+}
+
+func (c *Controller) deleteIngFunc(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+
+	ing, ok := obj.(*extensions.Ingress)
+
+	if !ok {
+		glog.Errorf("Expected object of type Ingress")
+		return
+	}
+
+	c.pending.forget(ing)
+
+	if val, ok := ing.Labels["acme-tls"]; !ok || val != "true" || *onDelete == onDeleteKeep {
+		return
+	}
+
+	for _, t := range ing.Spec.TLS {
+		glog.Infof("[%s] ingress %s deleted, handling secret in %s", t.SecretName, ing.Name, *deleteGracePeriod)
+		c.cleanupAfter(*deleteGracePeriod, ing, t)
+	}
+}
+
+// cleanupAfter calls cleanupIngressTLS for t of ing after d, waiting for
+// the secret to no longer be processed if another ingress is using it
+func (c *Controller) cleanupAfter(d time.Duration, ing *extensions.Ingress, t extensions.IngressTLS) {
+	c.after(d, func() {
+		if !c.busy.tryLock(ing.Namespace, t.SecretName) {
+			glog.Infof("[%s] secret is being processed, handling it in %s", t.SecretName, busyRetryDelay)
+			c.cleanupAfter(busyRetryDelay, ing, t)
+			return
+		}
+		defer c.busy.unlock(ing.Namespace, t.SecretName)
+
+		if err := c.cleanupIngressTLS(ing, t); err != nil {
+			glog.Errorf("[%s] error cleaning up secret: %s", t.SecretName, err.Error())
+		}
+	})
+}
+
+// cleanupIngressTLS deletes the secret and lock secrets for t, revoking the
+// certificate first if configured to, unless they are still used by another
+// ingress. Lock secrets are shared by the ingresses of every namespace.
+func (c *Controller) cleanupIngressTLS(ing *extensions.Ingress, t extensions.IngressTLS) error {
+	ings, err := c.ingresses.Ingress(api.NamespaceAll).List(api.ListOptions{})
+
+	if err != nil {
+		return fmt.Errorf("error listing ingresses: %s", err.Error())
+	}
+
+	for _, other := range ings.Items {
+		if other.Namespace != ing.Namespace {
+			continue
+		}
+
+		for _, ot := range other.Spec.TLS {
+			if ot.SecretName == t.SecretName {
+				glog.Infof("[%s] secret is still used by ingress %s, keeping it", t.SecretName, other.Name)
+				return nil
+			}
+		}
+	}
+
+	secret, err := c.secrets.Secrets(ing.Namespace).Get(t.SecretName)
+
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !isAcmeManaged(secret) {
+		return fmt.Errorf("secret is not acme managed")
+	}
+
+	if *onDelete == onDeleteRevoke {
+		if err := c.revokeSecret(ing, secret); err != nil {
+			return fmt.Errorf("error revoking certificate, keeping secret: %s", err.Error())
+		}
+
+		glog.Infof("[%s] revoked certificate", t.SecretName)
+	}
+
+	if err := c.secrets.Secrets(ing.Namespace).Delete(t.SecretName); err != nil {
+		return fmt.Errorf("error deleting secret: %s", err.Error())
+	}
+
+	glog.Infof("[%s] deleted secret", t.SecretName)
+
+	for _, host := range t.Hosts {
+		if err := c.cleanupLockSecret(host, ings.Items); err != nil {
+			glog.Errorf("[%s] error cleaning up lock secret for '%s': %s", t.SecretName, host, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) revokeSecret(ing *extensions.Ingress, secret *api.Secret) error {
+	tlsSecret, err := monitor.TLSSecretFromSecret(secret)
+
+	if err != nil {
+		return err
+	}
+
+	// revoke with the issuer that issued the certificate if it is known
+	acmeImpl, ok := c.issuers[tlsSecret.Issuer]
+
+	if !ok {
+		if acmeImpl, err = c.issuerForIngress(ing); err != nil {
+			return err
+		}
+	}
+
+	return acmeImpl.RevokeCertificate(tlsSecret.Certificate())
+}
+
+// cleanupLockSecret deletes the lock secret for host, unless it is still
+// used by one of ings or is currently held
+func (c *Controller) cleanupLockSecret(host string, ings []extensions.Ingress) error {
+	for _, ing := range ings {
+		for _, t := range ing.Spec.TLS {
+			for _, h := range t.Hosts {
+				if h == host {
+					return nil
+				}
+			}
+		}
+	}
+
+	name := acmeimpl.ChallengeSecretName(host)
+
+	secret, err := c.secrets.Secrets("acme").Get(name)
+
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if lock, err := locking.NewKubeLock(secret); err == nil && c.clock.Now().Before(lock.GetExpiry()) {
+		return fmt.Errorf("lock is currently held")
+	}
+
+	return c.secrets.Secrets("acme").Delete(name)
+}
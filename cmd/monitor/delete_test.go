@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+)
+
+func TestCleanupIngressTLS(t *testing.T) {
+	hosts := []string{"example.com", "www.example.com"}
+	c := newTestController(t, tlsSecret(t, "example-tls", hosts, time.Hour, time.Hour))
+
+	for _, h := range hosts {
+		if _, err := c.secrets.Secrets("acme").Create(createSecretLock(h, "acme", testNow.Add(-time.Hour))); err != nil {
+			t.Fatalf("error creating lock secret: %s", err.Error())
+		}
+	}
+
+	// an ingress in another namespace still uses example.com
+	other := testIngress("example.com")
+	other.Namespace = "other"
+
+	if _, err := c.ingresses.Ingress("other").Create(other); err != nil {
+		t.Fatalf("error creating ingress: %s", err.Error())
+	}
+
+	deleted := testIngress(hosts...)
+
+	if err := c.cleanupIngressTLS(deleted, deleted.Spec.TLS[0]); err != nil {
+		t.Fatalf("error cleaning up: %s", err.Error())
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err == nil {
+		t.Errorf("expected the secret to be deleted")
+	}
+
+	if _, err := c.secrets.Secrets("acme").Get(acmeimpl.ChallengeSecretName("example.com")); err != nil {
+		t.Errorf("expected the lock secret of a host used in another namespace to be kept: %s", err.Error())
+	}
+
+	if _, err := c.secrets.Secrets("acme").Get(acmeimpl.ChallengeSecretName("www.example.com")); err == nil {
+		t.Errorf("expected the lock secret of an unused host to be deleted")
+	}
+}
+
+func TestCleanupIngressTLSSecretStillUsed(t *testing.T) {
+	hosts := []string{"example.com"}
+	c := newTestController(t, tlsSecret(t, "example-tls", hosts, time.Hour, time.Hour))
+
+	// an ingress with the same secret name in another namespace does not
+	// use this secret
+	other := testIngress(hosts...)
+	other.Namespace = "other"
+
+	if _, err := c.ingresses.Ingress("other").Create(other); err != nil {
+		t.Fatalf("error creating ingress: %s", err.Error())
+	}
+
+	deleted := testIngress(hosts...)
+
+	if err := c.cleanupIngressTLS(deleted, deleted.Spec.TLS[0]); err != nil {
+		t.Fatalf("error cleaning up: %s", err.Error())
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err == nil {
+		t.Fatalf("expected the secret to be deleted")
+	}
+
+	// the ingress is recreated under another name with the same secret
+	c.secrets.Secrets("default").Create(tlsSecret(t, "example-tls", hosts, time.Hour, time.Hour))
+
+	renamed := testIngress(hosts...)
+	renamed.Name = "renamed"
+
+	if _, err := c.ingresses.Ingress("default").Create(renamed); err != nil {
+		t.Fatalf("error creating ingress: %s", err.Error())
+	}
+
+	if err := c.cleanupIngressTLS(deleted, deleted.Spec.TLS[0]); err != nil {
+		t.Fatalf("error cleaning up: %s", err.Error())
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err != nil {
+		t.Errorf("expected a secret still used by an ingress to be kept: %s", err.Error())
+	}
+}
@@ -0,0 +1,110 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+var (
+	challengeType = flag.String("challengeType", string(acme.HTTP01), "the default challenge type to solve, overridden with the acme-challenge-type annotation")
+	dnsProvider   = flag.String("dnsProvider", "", "the default dns provider for dns-01 challenges, overridden with the acme-dns-provider annotation")
+	selfCheck     = flag.Bool("selfCheck", false, "check that http-01 challenges are served correctly before asking the acme server to validate them, overridden with the acme-self-check annotation")
+
+	kubeAcmeDNS = flag.Bool("kubeAcmeDNS", false, "enables the 'kube-acme' dns provider, which answers dns-01 challenges from the dns server of the serve command")
+
+	acmeDNSServer = flag.String("acmeDNSServer", "", "url of an acme-dns server. enables the 'acme-dns' dns provider")
+
+	rfc2136Nameserver      = flag.String("rfc2136Nameserver", "", "host:port of the nameserver to send RFC 2136 dns updates to. enables the 'rfc2136' dns provider")
+	rfc2136Zone            = flag.String("rfc2136Zone", "", "the zone to update, discovered from the nameserver if not set")
+	rfc2136TSIGAlgorithm   = flag.String("rfc2136TSIGAlgorithm", "hmac-sha256.", "the TSIG algorithm used to sign dns updates")
+	rfc2136TSIGKey         = flag.String("rfc2136TSIGKey", "", "the name of the TSIG key used to sign dns updates")
+	rfc2136TSIGSecret      = flag.String("rfc2136TSIGSecret", "", "the base64 encoded TSIG secret used to sign dns updates")
+	rfc2136PropagationWait = flag.Duration("rfc2136PropagationWait", 0, "how long to wait after an update for it to reach secondary nameservers")
+)
+
+func initDNSProviders(kubeClient *client.Client) (acmeimpl.DNSProviders, error) {
+	providers := make(acmeimpl.DNSProviders)
+
+	if *kubeAcmeDNS {
+		p, err := acmeimpl.NewSecretsProvider(kubeClient, "acme", acme.DNS01)
+
+		if err != nil {
+			return nil, err
+		}
+
+		providers["kube-acme"] = p
+	}
+
+	if len(*acmeDNSServer) > 0 {
+		p, err := acmeimpl.NewAcmeDNSProvider(kubeClient, *acmeDNSServer, "acme")
+
+		if err != nil {
+			return nil, err
+		}
+
+		providers["acme-dns"] = p
+	}
+
+	if len(*rfc2136Nameserver) > 0 {
+		p, err := acmeimpl.NewRFC2136Provider(*rfc2136Nameserver, *rfc2136Zone, *rfc2136TSIGAlgorithm, *rfc2136TSIGKey, *rfc2136TSIGSecret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.PropagationWait = *rfc2136PropagationWait
+		providers["rfc2136"] = p
+	}
+
+	if len(*dnsProvider) > 0 {
+		if _, ok := providers[*dnsProvider]; !ok {
+			return nil, fmt.Errorf("default dns provider '%s' is not configured", *dnsProvider)
+		}
+	}
+
+	return providers, nil
+}
+
+// setChallengeOptions sets the challenge type, dns provider and self check
+// for cr from the annotations on ing, falling back to the defaults. Requests containing
+// wildcard hosts always use dns-01.
+func setChallengeOptions(cr *acmeimpl.CertificateRequest, ing *extensions.Ingress) error {
+	cr.ChallengeType = acme.Challenge(*challengeType)
+	cr.DNSProvider = *dnsProvider
+
+	if t, ok := ing.Annotations["acme-challenge-type"]; ok && len(t) > 0 {
+		cr.ChallengeType = acme.Challenge(t)
+	}
+
+	for _, h := range cr.Hosts {
+		if acmeimpl.IsWildcard(h) {
+			cr.ChallengeType = acme.DNS01
+			break
+		}
+	}
+
+	if p, ok := ing.Annotations["acme-dns-provider"]; ok && len(p) > 0 {
+		cr.DNSProvider = p
+	}
+
+	cr.SelfCheck = *selfCheck
+
+	if v, ok := ing.Annotations["acme-self-check"]; ok && len(v) > 0 {
+		selfCheck, err := strconv.ParseBool(v)
+
+		if err != nil {
+			return fmt.Errorf("invalid acme-self-check value '%s'", v)
+		}
+
+		cr.SelfCheck = selfCheck
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+var (
+	waitForDNS = flag.Bool("waitForDNS", false, "hold certificate requests until every host resolves to the load balancer address in the ingress status. overridden with the acme-wait-for-dns annotation")
+)
+
+// dnsNotReadyError is returned when the hosts of a request do not resolve to
+// the load balancer of the ingress yet
+type dnsNotReadyError struct {
+	reason string
+}
+
+func (e *dnsNotReadyError) Error() string {
+	return fmt.Sprintf("waiting for dns: %s", e.reason)
+}
+
+// checkDNS returns a *dnsNotReadyError unless every host in cr resolves to an
+// address of the load balancer of ing. Wildcard hosts and dns-01 requests are
+// not checked, as they are validated without connecting to the host.
+func (c *Controller) checkDNS(cr *acmeimpl.CertificateRequest, ing *extensions.Ingress) error {
+	wait := *waitForDNS
+
+	if w, ok := ing.Annotations["acme-wait-for-dns"]; ok && len(w) > 0 {
+		wait = w == "true"
+	}
+
+	if !wait || cr.ChallengeType == acme.DNS01 {
+		return nil
+	}
+
+	lbAddrs, err := c.loadBalancerAddrs(ing)
+
+	if err != nil {
+		return err
+	}
+
+	for _, h := range cr.Hosts {
+		if acmeimpl.IsWildcard(h) {
+			continue
+		}
+
+		addrs, err := c.lookupHost(h)
+
+		if err != nil {
+			return &dnsNotReadyError{fmt.Sprintf("error resolving '%s': %s", h, err.Error())}
+		}
+
+		if !containsAny(lbAddrs, addrs) {
+			sort.Strings(addrs)
+			return &dnsNotReadyError{fmt.Sprintf("'%s' resolves to %s, not the ingress load balancer %s", h, addrs, lbAddrs)}
+		}
+	}
+
+	return nil
+}
+
+// loadBalancerAddrs returns the addresses of the load balancer in the status
+// of ing, resolving any hostnames
+func (c *Controller) loadBalancerAddrs(ing *extensions.Ingress) ([]string, error) {
+	var addrs []string
+
+	for _, lb := range ing.Status.LoadBalancer.Ingress {
+		if len(lb.IP) > 0 {
+			addrs = append(addrs, lb.IP)
+		}
+
+		if len(lb.Hostname) > 0 {
+			res, err := c.lookupHost(lb.Hostname)
+
+			if err != nil {
+				return nil, &dnsNotReadyError{fmt.Sprintf("error resolving load balancer '%s': %s", lb.Hostname, err.Error())}
+			}
+
+			addrs = append(addrs, res...)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, &dnsNotReadyError{"the ingress has no load balancer address yet"}
+	}
+
+	// sorted so that the reason a request is held for is stable
+	sort.Strings(addrs)
+
+	return addrs, nil
+}
+
+func containsAny(set, addrs []string) bool {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+
+		for _, s := range set {
+			if sip := net.ParseIP(s); (ip != nil && ip.Equal(sip)) || strings.EqualFold(a, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
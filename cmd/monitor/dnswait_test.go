@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// fakeResolver resolves the host names in it, and fails for any others
+type fakeResolver map[string][]string
+
+func (r fakeResolver) lookupHost(host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+
+	return nil, fmt.Errorf("no such host '%s'", host)
+}
+
+func TestCheckDNS(t *testing.T) {
+	resolver := fakeResolver{
+		"example.com":      {"10.0.0.1"},
+		"www.example.com":  {"10.0.0.2", "10.0.0.1"},
+		"other.com":        {"10.0.0.9"},
+		"lb.example.net":   {"10.0.0.1"},
+		"ipv6.example.com": {"2001:db8::1"},
+	}
+
+	tests := []struct {
+		name          string
+		hosts         []string
+		challengeType acme.Challenge
+		lbIP          string
+		lbHostname    string
+		wait          string
+
+		err string
+	}{
+		{name: "not waiting", hosts: []string{"other.com"}, lbIP: "10.0.0.1", wait: "false"},
+		{name: "resolved", hosts: []string{"example.com", "www.example.com"}, lbIP: "10.0.0.1", wait: "true"},
+		{name: "resolved to load balancer hostname", hosts: []string{"example.com"}, lbHostname: "lb.example.net", wait: "true"},
+		{name: "resolved ipv6", hosts: []string{"ipv6.example.com"}, lbIP: "2001:0db8:0:0:0:0:0:1", wait: "true"},
+		{name: "no load balancer", hosts: []string{"example.com"}, wait: "true", err: "no load balancer address"},
+		{name: "not resolving to load balancer", hosts: []string{"example.com", "other.com"}, lbIP: "10.0.0.1", wait: "true", err: "'other.com' resolves to [10.0.0.9]"},
+		{name: "not resolving", hosts: []string{"missing.example.com"}, lbIP: "10.0.0.1", wait: "true", err: "error resolving 'missing.example.com'"},
+		{name: "wildcard skipped", hosts: []string{"*.other.com", "example.com"}, lbIP: "10.0.0.1", wait: "true"},
+		{name: "dns-01 skipped", hosts: []string{"other.com"}, challengeType: acme.DNS01, lbIP: "10.0.0.1", wait: "true"},
+	}
+
+	for _, test := range tests {
+		c := newTestController(t)
+		c.lookupHost = resolver.lookupHost
+
+		ing := testIngress(test.hosts...)
+		ing.Annotations["acme-wait-for-dns"] = test.wait
+
+		if len(test.lbIP) > 0 || len(test.lbHostname) > 0 {
+			ing.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: test.lbIP, Hostname: test.lbHostname}}
+		}
+
+		cr := &acmeimpl.CertificateRequest{Hosts: test.hosts, ChallengeType: test.challengeType}
+
+		err := c.checkDNS(cr, ing)
+
+		if len(test.err) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			}
+			continue
+		}
+
+		if _, ok := err.(*dnsNotReadyError); !ok || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected dns error containing '%s', got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestWaitForDNSDefersRequest(t *testing.T) {
+	c := newTestController(t)
+	resolver := fakeResolver{"example.com": {"10.0.0.2"}}
+	c.lookupHost = resolver.lookupHost
+
+	old := testIngress("example.com")
+	old.Annotations["acme-wait-for-dns"] = "true"
+
+	c.addIngFunc(old)
+
+	if n := c.issuer.count(); n != 0 {
+		t.Errorf("expected no request before a load balancer is assigned, got %d", n)
+	}
+
+	if !c.pending.has(old) {
+		t.Fatalf("expected the ingress to be pending")
+	}
+
+	cur := testIngress("example.com")
+	cur.Annotations["acme-wait-for-dns"] = "true"
+	cur.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: "10.0.0.1"}}
+
+	c.updateIngFunc(old, cur)
+
+	if n := c.issuer.count(); n != 0 {
+		t.Errorf("expected no request while the host resolves elsewhere, got %d", n)
+	}
+
+	if !c.pending.has(cur) {
+		t.Fatalf("expected the ingress to be pending")
+	}
+
+	resolver["example.com"] = []string{"10.0.0.1"}
+	c.updateIngFunc(cur, cur)
+
+	if n := c.issuer.count(); n != 1 {
+		t.Fatalf("expected a request once the host resolves to the load balancer, got %d", n)
+	}
+
+	if c.pending.has(cur) {
+		t.Errorf("expected the ingress to no longer be pending")
+	}
+
+	if _, err := c.secrets.Secrets("default").Get("example-tls"); err != nil {
+		t.Errorf("expected the certificate to be saved: %s", err.Error())
+	}
+}
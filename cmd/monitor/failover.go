@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/ratelimit"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+var (
+	acmeFailover = flag.String("acmeFailover", "", "comma separated, ordered list of issuer names to fall back through when a certificate request fails with one of the failoverOn errors. ingresses fall back to the issuers listed after their own")
+	failoverOn   = flag.String("failoverOn", "network,rateLimited,serverInternal", "comma separated list of the errors to fall back to the next issuer on, from network, rateLimited, serverInternal, validation and other")
+)
+
+func splitList(s string) []string {
+	var res []string
+
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			res = append(res, p)
+		}
+	}
+
+	return res
+}
+
+func validateFailover(issuers map[string]acmeimpl.Interface) error {
+	for _, name := range splitList(*acmeFailover) {
+		if _, ok := issuers[name]; !ok {
+			return fmt.Errorf("unknown acme issuer '%s' in acmeFailover", name)
+		}
+	}
+
+	for _, c := range splitList(*failoverOn) {
+		switch acmeimpl.ErrorClass(c) {
+		case acmeimpl.ErrorNetwork, acmeimpl.ErrorRateLimited, acmeimpl.ErrorServerInternal, acmeimpl.ErrorValidation, acmeimpl.ErrorOther:
+		default:
+			return fmt.Errorf("invalid failoverOn error '%s'", c)
+		}
+	}
+
+	return nil
+}
+
+// issuerChain returns the names of the issuers to try for ing in order. If
+// the issuer of ing is in the acmeFailover list, it is followed by the
+// issuers after it in the list.
+func issuerChain(ing *extensions.Ingress) []string {
+	name := issuerNameForIngress(ing)
+	failover := splitList(*acmeFailover)
+
+	for i, n := range failover {
+		if n == name {
+			return failover[i:]
+		}
+	}
+
+	return []string{name}
+}
+
+// shouldFailover returns true if err is one of the failoverOn errors
+func shouldFailover(err error) bool {
+	class := acmeimpl.ClassifyError(err)
+
+	if _, ok := err.(*ratelimit.LimitError); ok {
+		class = acmeimpl.ErrorRateLimited
+	}
+
+	for _, c := range splitList(*failoverOn) {
+		if acmeimpl.ErrorClass(c) == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// obtainCertificate requests a certificate for cr from each issuer in the
+// chain for ing in turn, until one succeeds or fails with an error that is
+// not failed over on. It returns the name of the last issuer tried.
+func (c *Controller) obtainCertificate(ing *extensions.Ingress, cr *acmeimpl.CertificateRequest) (*acme.CertificateResource, string, error) {
+	chain := issuerChain(ing)
+
+	for i, name := range chain {
+		impl, ok := c.issuers[name]
+
+		if !ok {
+			return nil, name, fmt.Errorf("unknown acme issuer '%s'", name)
+		}
+
+		err := c.checkRateLimits(name, cr)
+
+		if err == nil {
+			var certs *acme.CertificateResource
+			certs, err = impl.Perform(cr)
+			c.recordIssuance(name, cr.Hosts, err)
+
+			if err == nil {
+				return certs, name, nil
+			}
+		}
+
+		if i == len(chain)-1 || !shouldFailover(err) {
+			return nil, name, err
+		}
+
+		glog.Errorf("[%s] issuer '%s' failed, falling back to '%s': %s", strings.Join(cr.Hosts, ","), name, chain[i+1], err.Error())
+	}
+
+	return nil, "", fmt.Errorf("no acme issuers to request a certificate from")
+}
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/monitor"
+	"github.com/xenolf/lego/acme"
+)
+
+// issuer is a named acme server, each with its own user account
+type issuer struct {
+	name       string
+	server     string
+	userSecret string
+}
+
+// parseIssuers returns the issuers configured with -acmeServer and
+// -acmeIssuers. The issuer for -acmeServer is always named 'default'.
+func parseIssuers() ([]issuer, error) {
+	res := []issuer{
+		{
+			name:       "default",
+			server:     *acmeServer,
+			userSecret: *acmeUserSecret,
+		},
+	}
+
+	if len(*acmeIssuers) == 0 {
+		return res, nil
+	}
+
+	for _, s := range strings.Split(*acmeIssuers, ",") {
+		parts := strings.SplitN(strings.TrimSpace(s), "=", 2)
+
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid issuer '%s', expected name=url", s)
+		}
+
+		for _, i := range res {
+			if i.name == parts[0] {
+				return nil, fmt.Errorf("issuer '%s' is defined more than once", parts[0])
+			}
+		}
+
+		res = append(res, issuer{
+			name:       parts[0],
+			server:     parts[1],
+			userSecret: fmt.Sprintf("%s-%s", *acmeUserSecret, parts[0]),
+		})
+	}
+
+	return res, nil
+}
+
+func initIssuers(kubeClient *client.Client) (map[string]acmeimpl.Interface, error) {
+	is, err := parseIssuers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	dnsProviders, err := initDNSProviders(kubeClient)
+
+	if err != nil {
+		return nil, fmt.Errorf("error initialising dns providers: %s", err.Error())
+	}
+
+	res := make(map[string]acmeimpl.Interface, len(is))
+
+	for _, i := range is {
+		user, err := loadAcmeUser(kubeClient, i)
+
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %s", i.name, err.Error())
+		}
+
+		impl, err := acmeimpl.NewAcmeImpl(kubeClient, i.server, user, acme.KeyType(*keyType), dnsProviders)
+
+		if err != nil {
+			return nil, fmt.Errorf("[%s] error initialising acmeimpl: %s", i.name, err.Error())
+		}
+
+		if _, err := impl.Client(); err != nil {
+			glog.Errorf("[%s] error connecting to acme server, retrying on the first request: %s", i.name, err.Error())
+		}
+
+		res[i.name] = impl
+	}
+
+	if _, ok := res[*defaultIssuer]; !ok {
+		return nil, fmt.Errorf("default issuer '%s' is not configured", *defaultIssuer)
+	}
+
+	return res, nil
+}
+
+// issuerNameForIngress returns the name in the acme-issuer annotation of ing,
+// or the default issuer if the annotation is not set
+func issuerNameForIngress(ing *extensions.Ingress) string {
+	if n, ok := ing.Annotations["acme-issuer"]; ok && len(n) > 0 {
+		return n
+	}
+	return *defaultIssuer
+}
+
+// issuerForIngress returns the issuer named by issuerNameForIngress
+func (c *Controller) issuerForIngress(ing *extensions.Ingress) (acmeimpl.Interface, error) {
+	name := issuerNameForIngress(ing)
+
+	if impl, ok := c.issuers[name]; ok {
+		return impl, nil
+	}
+
+	return nil, fmt.Errorf("unknown acme issuer '%s'", name)
+}
+
+// loadAcmeUser loads the acme user for the default issuer from the acmeKey
+// and acmeReg files if they exist. Otherwise the user is read from the
+// issuers user secret, registering a new account with the acme server and
+// storing it in the secret if it does not already exist.
+func loadAcmeUser(kubeClient *client.Client, i issuer) (acmeimpl.User, error) {
+	if i.name == "default" && fileExists(*acmeKey) && fileExists(*acmeReg) {
+		privKey, err := loadAcmePrivateKey(*acmeKey)
+
+		if err != nil {
+			return acmeimpl.User{}, fmt.Errorf("error loading acme private key: %s", err.Error())
+		}
+
+		reg, err := loadAcmeRegistration(*acmeReg)
+
+		if err != nil {
+			return acmeimpl.User{}, fmt.Errorf("error loading acme registration: %s", err.Error())
+		}
+
+		return acmeimpl.NewUser(*acmeEmail, privKey, reg), nil
+	}
+
+	secret, err := kubeClient.Secrets("acme").Get(i.userSecret)
+
+	if err == nil {
+		us, err := monitor.UserSecretFromSecret(secret)
+
+		if err != nil {
+			return acmeimpl.User{}, fmt.Errorf("error loading acme user from secret '%s': %s", i.userSecret, err.Error())
+		}
+
+		return us.User, nil
+	}
+
+	if !kerrors.IsNotFound(err) {
+		return acmeimpl.User{}, fmt.Errorf("error getting acme user secret '%s': %s", i.userSecret, err.Error())
+	}
+
+	glog.Infof("[%s] Registering new acme user '%s' with %s", i.name, *acmeEmail, i.server)
+
+	user, err := acmeimpl.Register(i.server, *acmeEmail)
+
+	if err != nil {
+		return acmeimpl.User{}, fmt.Errorf("error registering acme user: %s", err.Error())
+	}
+
+	us := monitor.UserSecret{
+		Name:      i.userSecret,
+		Namespace: "acme",
+		User:      user,
+	}
+
+	secret, err = us.Secret()
+
+	if err != nil {
+		return acmeimpl.User{}, fmt.Errorf("error creating acme user secret: %s", err.Error())
+	}
+
+	if _, err := kubeClient.Secrets(secret.Namespace).Create(secret); err != nil {
+		return acmeimpl.User{}, fmt.Errorf("error saving acme user secret: %s", err.Error())
+	}
+
+	glog.Infof("[%s] Saved new acme user to secret '%s'", i.name, i.userSecret)
+
+	return user, nil
+}
@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+var (
+	keyType     = flag.String("keyType", string(acme.RSA2048), "the default type of certificate private keys, one of P256, P384, 2048, 4096 or 8192. overridden with the acme-key-type annotation")
+	keyRotation = flag.String("keyRotation", keyRotationReuse, "whether to 'reuse' the private key when renewing certificates, or 'rotate' it on every renewal. overridden with the acme-key-rotation annotation")
+	maxKeyAge   = flag.Duration("maxKeyAge", 0, "if set, certificates are renewed with a new private key once their key is this old. overridden with the acme-max-key-age annotation")
+)
+
+const (
+	keyRotationReuse  = "reuse"
+	keyRotationRotate = "rotate"
+)
+
+// keyPolicy decides when the private key of a certificate is replaced
+type keyPolicy struct {
+	// keyType is the type of new keys. an existing key of another type is
+	// replaced, even if its certificate is still valid
+	keyType acme.KeyType
+	// rotate generates a new key on every renewal
+	rotate bool
+	// maxAge forces renewal with a new key once the key is older than it
+	maxAge time.Duration
+}
+
+// keyPolicyForIngress returns the key policy from the annotations on ing,
+// falling back to the defaults
+func keyPolicyForIngress(ing *extensions.Ingress) (keyPolicy, error) {
+	kt, err := acmeimpl.ParseKeyType(*keyType)
+
+	if err != nil {
+		return keyPolicy{}, err
+	}
+
+	if t, ok := ing.Annotations["acme-key-type"]; ok && len(t) > 0 {
+		if kt, err = acmeimpl.ParseKeyType(t); err != nil {
+			return keyPolicy{}, err
+		}
+	}
+
+	rotation := *keyRotation
+	policy := keyPolicy{keyType: kt, maxAge: *maxKeyAge}
+
+	if r, ok := ing.Annotations["acme-key-rotation"]; ok && len(r) > 0 {
+		rotation = r
+	}
+
+	switch rotation {
+	case keyRotationReuse:
+	case keyRotationRotate:
+		policy.rotate = true
+	default:
+		return keyPolicy{}, fmt.Errorf("invalid key rotation policy '%s'", rotation)
+	}
+
+	if a, ok := ing.Annotations["acme-max-key-age"]; ok && len(a) > 0 {
+		d, err := time.ParseDuration(a)
+
+		if err != nil {
+			return keyPolicy{}, fmt.Errorf("invalid max key age '%s': %s", a, err.Error())
+		}
+
+		policy.maxAge = d
+	}
+
+	return policy, nil
+}
+
+// csrOptionsForIngress returns the optional certificate features from the
+// annotations on ing
+func csrOptionsForIngress(ing *extensions.Ingress) (acmeimpl.CSROptions, error) {
+	var opts acmeimpl.CSROptions
+
+	if v, ok := ing.Annotations["acme-must-staple"]; ok && len(v) > 0 {
+		mustStaple, err := strconv.ParseBool(v)
+
+		if err != nil {
+			return opts, fmt.Errorf("invalid acme-must-staple value '%s'", v)
+		}
+
+		opts.MustStaple = mustStaple
+	}
+
+	return opts, nil
+}
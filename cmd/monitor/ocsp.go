@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/monitor"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ocsp"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+var (
+	ocspInterval = flag.Duration("ocspInterval", time.Hour, "how often to check the OCSP status of acme managed certificates, reissuing revoked ones and refreshing OCSP staples past half of their validity. 0 disables OCSP")
+)
+
+// watchOCSP checks the OCSP status of all acme managed secrets every
+// ocspInterval
+func (c *Controller) watchOCSP() {
+	if *ocspInterval == 0 {
+		return
+	}
+
+	for {
+		c.checkAllOCSP()
+		<-c.clock.After(*ocspInterval)
+	}
+}
+
+func (c *Controller) checkAllOCSP() {
+	secrets, err := c.secrets.Secrets(api.NamespaceAll).List(api.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"acme-managed": "true"}),
+	})
+
+	if err != nil {
+		glog.Errorf("error listing secrets to check OCSP status: %s", err.Error())
+		return
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+
+		if secret.Labels["acme-lock"] == "true" {
+			continue
+		}
+
+		if err := c.checkOCSP(secret); err != nil {
+			glog.Errorf("[%s] error checking OCSP status: %s", secret.Name, err.Error())
+		}
+	}
+}
+
+// checkOCSP fetches the OCSP response for the certificate in secret. Revoked
+// certificates are reissued, and the response is stored as the OCSP staple
+// if the stored one is no longer fresh.
+func (c *Controller) checkOCSP(secret *api.Secret) error {
+	tlsSecret, err := monitor.TLSSecretFromSecret(secret)
+
+	if err != nil {
+		// no certificate has been issued into this secret yet
+		return nil
+	}
+
+	staple, resp, err := c.getOCSP(tlsSecret.Certificate())
+
+	if err != nil {
+		return fmt.Errorf("error fetching OCSP response: %s", err.Error())
+	}
+
+	switch resp.Status {
+	case acme.OCSPRevoked:
+		return c.reissueRevoked(secret, resp)
+	case acme.OCSPGood:
+	default:
+		return fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+
+	if !ocspStapleNeedsRefresh(tlsSecret.OCSPStaple, c.clock.Now()) {
+		return nil
+	}
+
+	secret.Data[monitor.OCSPStapleKey] = staple
+
+	if _, err := c.secrets.Secrets(secret.Namespace).Update(secret); err != nil {
+		return fmt.Errorf("error saving OCSP staple: %s", err.Error())
+	}
+
+	glog.Infof("[%s] stored OCSP staple valid until %s", secret.Name, resp.NextUpdate)
+
+	return nil
+}
+
+// reissueRevoked marks secret as revoked, so that it is renewed with a new
+// private key, and processes the ingresses that use it
+func (c *Controller) reissueRevoked(secret *api.Secret, resp *ocsp.Response) error {
+	glog.Warningf("[%s] certificate was revoked at %s (reason %d), reissuing it with a new private key", secret.Name, resp.RevokedAt, resp.RevocationReason)
+
+	if _, ok := secret.Annotations["acme-revoked"]; !ok {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+
+		secret.Annotations["acme-revoked"] = resp.RevokedAt.UTC().Format(time.RFC3339)
+		delete(secret.Data, monitor.OCSPStapleKey)
+
+		if _, err := c.secrets.Secrets(secret.Namespace).Update(secret); err != nil {
+			return fmt.Errorf("error marking secret as revoked: %s", err.Error())
+		}
+	}
+
+	ings, err := c.ingresses.Ingress(secret.Namespace).List(api.ListOptions{})
+
+	if err != nil {
+		return fmt.Errorf("error listing ingresses: %s", err.Error())
+	}
+
+	for i := range ings.Items {
+		for _, t := range ings.Items[i].Spec.TLS {
+			if t.SecretName == secret.Name {
+				c.addIngFunc(&ings.Items[i])
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchOCSPStaple fetches the OCSP response for the leaf certificate in
+// bundle from the responder named in it
+func (c *Controller) fetchOCSPStaple(bundle []byte) ([]byte, *ocsp.Response, error) {
+	staple, resp, err := c.getOCSP(bundle)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching OCSP response: %s", err.Error())
+	}
+
+	if resp.Status != acme.OCSPGood {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d, not storing it", resp.Status)
+	}
+
+	return staple, resp, nil
+}
+
+// ocspStapleNeedsRefresh returns true if staple is missing, invalid, or past
+// half way between its ThisUpdate and NextUpdate times
+func ocspStapleNeedsRefresh(staple []byte, now time.Time) bool {
+	if len(staple) == 0 {
+		return true
+	}
+
+	resp, err := ocsp.ParseResponse(staple, nil)
+
+	if err != nil || resp.NextUpdate.IsZero() {
+		return true
+	}
+
+	refreshAt := resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+
+	return !now.Before(refreshAt)
+}
@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponder is a local OCSP responder for certificates issued by its CA,
+// answering with status for every request
+type ocspResponder struct {
+	*httptest.Server
+
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	status int
+}
+
+func newOCSPResponder(t *testing.T) *ocspResponder {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating ca key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+
+	if err != nil {
+		t.Fatalf("error creating ca certificate: %s", err.Error())
+	}
+
+	ca, _ := x509.ParseCertificate(der)
+
+	r := &ocspResponder{ca: ca, caKey: key, status: ocsp.Good}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+
+	return r
+}
+
+func (r *ocspResponder) handle(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	ocspReq, err := ocsp.ParseRequest(body)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, err := r.response(ocspReq.SerialNumber, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+func (r *ocspResponder) response(serial *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	tmpl := ocsp.Response{
+		Status:       r.status,
+		SerialNumber: serial,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   nextUpdate,
+	}
+
+	if r.status == ocsp.Revoked {
+		tmpl.RevokedAt = thisUpdate
+		tmpl.RevocationReason = 1 // keyCompromise
+	}
+
+	return ocsp.CreateResponse(r.ca, r.ca, tmpl, r.caKey)
+}
+
+// bundle returns a PEM bundle of a leaf certificate that names the responder
+// as its OCSP server, followed by the CA certificate
+func (r *ocspResponder) bundle(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{r.URL},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, r.ca, key.Public(), r.caKey)
+
+	if err != nil {
+		t.Fatalf("error creating certificate: %s", err.Error())
+	}
+
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.ca.Raw})...)
+}
+
+func TestFetchOCSPStaple(t *testing.T) {
+	r := newOCSPResponder(t)
+	defer r.Close()
+
+	c := &Controller{getOCSP: acme.GetOCSPForCert}
+	bundle := r.bundle(t)
+
+	staple, resp, err := c.fetchOCSPStaple(bundle)
+
+	if err != nil {
+		t.Fatalf("error fetching OCSP staple: %s", err.Error())
+	}
+
+	if resp.Status != ocsp.Good {
+		t.Errorf("expected status good, got %d", resp.Status)
+	}
+
+	if _, err := ocsp.ParseResponse(staple, r.ca); err != nil {
+		t.Errorf("expected the staple to be a valid OCSP response: %s", err.Error())
+	}
+
+	r.status = ocsp.Revoked
+
+	if _, _, err := c.fetchOCSPStaple(bundle); err == nil {
+		t.Errorf("expected an error fetching the staple of a revoked certificate")
+	}
+}
+
+func TestOCSPStapleNeedsRefresh(t *testing.T) {
+	r := newOCSPResponder(t)
+	defer r.Close()
+
+	thisUpdate := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	staple, err := r.response(big.NewInt(2), thisUpdate, thisUpdate.Add(time.Hour*96))
+
+	if err != nil {
+		t.Fatalf("error creating OCSP response: %s", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		staple   []byte
+		now      time.Time
+		expected bool
+	}{
+		{"missing", nil, thisUpdate, true},
+		{"invalid", []byte("not a response"), thisUpdate, true},
+		{"fresh", staple, thisUpdate.Add(time.Hour), false},
+		{"just before half way", staple, thisUpdate.Add(time.Hour*48 - time.Second), false},
+		{"half way", staple, thisUpdate.Add(time.Hour * 48), true},
+		{"expired", staple, thisUpdate.Add(time.Hour * 100), true},
+	}
+
+	for _, test := range tests {
+		if res := ocspStapleNeedsRefresh(test.staple, test.now); res != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, res)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// pendingSet holds the reason each deferred secret of an ingress was deferred
+// for, keyed by the ingresses namespace/name, so they are processed again on
+// the next resync
+type pendingSet struct {
+	sync.Mutex
+	ings map[string]map[string]string
+}
+
+func newPendingSet() *pendingSet {
+	return &pendingSet{ings: map[string]map[string]string{}}
+}
+
+func ingressKey(ing *extensions.Ingress) string {
+	return fmt.Sprintf("%s/%s", ing.Namespace, ing.Name)
+}
+
+// deferSecret adds the secret t of ing to deferred, logging the reason unless
+// it was already deferred for the same reason
+func (p *pendingSet) deferSecret(ing *extensions.Ingress, deferred map[string]string, t extensions.IngressTLS, reason string) {
+	p.Lock()
+	prev, ok := p.ings[ingressKey(ing)][t.SecretName]
+	p.Unlock()
+
+	if !ok || prev != reason {
+		glog.Infof("[%s] deferring certificate for hosts %s: %s", t.SecretName, t.Hosts, reason)
+	}
+
+	deferred[t.SecretName] = reason
+}
+
+// set replaces the deferred secrets of ing
+func (p *pendingSet) set(ing *extensions.Ingress, deferred map[string]string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(deferred) == 0 {
+		delete(p.ings, ingressKey(ing))
+		return
+	}
+
+	p.ings[ingressKey(ing)] = deferred
+}
+
+// has returns true if any secret of ing has been deferred
+func (p *pendingSet) has(ing *extensions.Ingress) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	_, ok := p.ings[ingressKey(ing)]
+	return ok
+}
+
+// forget removes ing from the set
+func (p *pendingSet) forget(ing *extensions.Ingress) {
+	p.set(ing, nil)
+}
@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/ratelimit"
+	"github.com/namsral/flag"
+)
+
+var (
+	rateLimitLedger        = flag.String("rateLimitLedger", "kube-acme-ledger", "name of the secret in the acme namespace issuances, failed validations and failed requests are recorded in")
+	rateLimitPerDomain     = flag.Int("rateLimitPerDomain", 50, "the number of new certificates to issue per registered domain within rateLimitWindow. 0 disables the limit")
+	rateLimitDuplicates    = flag.Int("rateLimitDuplicates", 5, "the number of certificates to issue for the exact same set of hosts within rateLimitWindow. 0 disables the limit")
+	rateLimitWindow        = flag.Duration("rateLimitWindow", time.Hour*24*7, "the window certificate issuances are counted over")
+	rateLimitFailures      = flag.Int("rateLimitFailures", 5, "the number of failed validations to allow per host within rateLimitFailureWindow. 0 disables the limit")
+	rateLimitFailureWindow = flag.Duration("rateLimitFailureWindow", time.Hour, "the window failed validations are counted over")
+)
+
+func rateLimits() ratelimit.Limits {
+	return ratelimit.Limits{
+		CertificatesPerDomain:  *rateLimitPerDomain,
+		DuplicateCertificates:  *rateLimitDuplicates,
+		Window:                 *rateLimitWindow,
+		FailedValidations:      *rateLimitFailures,
+		FailedValidationWindow: *rateLimitFailureWindow,
+	}
+}
+
+// checkRateLimits returns a *ratelimit.LimitError if requesting cr from
+// issuer would exceed the configured limits
+func (c *Controller) checkRateLimits(issuer string, cr *acmeimpl.CertificateRequest) error {
+	l, err := c.ledger.Load()
+
+	if err != nil {
+		return err
+	}
+
+	return l.Check(issuer, cr.Hosts, cr.IsRenewal, c.limits, c.clock.Now())
+}
+
+// recordIssuance records the outcome of requesting a certificate for hosts
+// from issuer in the ledger
+func (c *Controller) recordIssuance(issuer string, hosts []string, performErr error) {
+	now := c.clock.Now()
+
+	err := c.ledger.Update(func(l *ratelimit.Ledger) {
+		l.Prune(c.limits, now)
+
+		if performErr == nil {
+			l.RecordIssuance(issuer, hosts, now)
+			return
+		}
+
+		if ae, ok := performErr.(*acmeimpl.AuthorizationError); ok {
+			for _, h := range ae.FailedValidations() {
+				l.RecordFailedValidation(issuer, []string{h}, now)
+			}
+		}
+	})
+
+	if err != nil {
+		glog.Errorf("error updating rate limit ledger: %s", err.Error())
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/namsral/flag"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+var (
+	renewCheckInterval = flag.Duration("renewCheckInterval", time.Hour, "how often to check every acme-tls ingress for certificates due for renewal or private keys older than maxKeyAge. 0 disables the check")
+)
+
+// watchRenewals checks all acme-tls ingresses every renewCheckInterval, as
+// resyncs of unchanged ingresses are not processed
+func (c *Controller) watchRenewals() {
+	if *renewCheckInterval == 0 {
+		return
+	}
+
+	for {
+		<-c.clock.After(*renewCheckInterval)
+		c.checkAllIngresses()
+	}
+}
+
+// checkAllIngresses processes every acme-tls ingress, renewing certificates
+// that are close to expiry or have a private key older than maxKeyAge
+func (c *Controller) checkAllIngresses() {
+	ings, err := c.ingresses.Ingress(api.NamespaceAll).List(api.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"acme-tls": "true"}),
+	})
+
+	if err != nil {
+		glog.Errorf("error listing ingresses to check for renewals: %s", err.Error())
+		return
+	}
+
+	for i := range ings.Items {
+		c.addIngFunc(&ings.Items[i])
+	}
+}
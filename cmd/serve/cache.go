@@ -0,0 +1,137 @@
+package serve
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+)
+
+// challenge is a challenge presented in a lock secret
+type challenge struct {
+	acmeimpl.PresentedChallenge
+	// seen is when the challenge was first seen, used to order challenges
+	// presented for the same host
+	seen time.Time
+}
+
+// challengeCache holds the challenges presented in the secrets of the acme
+// namespace, keyed by secret name and challenge key, so that requests are
+// answered without contacting the apiserver
+type challengeCache struct {
+	sync.RWMutex
+	secrets map[string]map[string]challenge
+}
+
+func newChallengeCache() *challengeCache {
+	return &challengeCache{secrets: map[string]map[string]challenge{}}
+}
+
+// get returns the challenge of type challengeType presented with token in
+// the secret name
+func (c *challengeCache) get(name string, challengeType acme.Challenge, token string) (challenge, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	ch, ok := c.secrets[name][acmeimpl.ChallengeKey(challengeType, token)]
+	return ch, ok
+}
+
+// list returns the challenges of type challengeType presented in the secret
+// name, oldest first
+func (c *challengeCache) list(name string, challengeType acme.Challenge) []challenge {
+	c.RLock()
+	defer c.RUnlock()
+
+	var res []challenge
+	for _, ch := range c.secrets[name] {
+		if ch.Type == challengeType {
+			res = append(res, ch)
+		}
+	}
+
+	sort.Sort(bySeen(res))
+
+	return res
+}
+
+func (c *challengeCache) addFunc(obj interface{}) {
+	secret, ok := obj.(*api.Secret)
+
+	if !ok {
+		glog.Errorf("Expected object of type Secret")
+		return
+	}
+
+	presented := acmeimpl.Challenges(secret)
+
+	if len(presented) == 0 {
+		c.remove(secret.Name)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	chs := make(map[string]challenge, len(presented))
+
+	for _, p := range presented {
+		key := acmeimpl.ChallengeKey(p.Type, p.Token)
+
+		seen := now
+		if prev, ok := c.secrets[secret.Name][key]; ok {
+			seen = prev.seen
+		}
+
+		chs[key] = challenge{
+			PresentedChallenge: p,
+			seen:               seen,
+		}
+	}
+
+	c.secrets[secret.Name] = chs
+}
+
+func (c *challengeCache) updateFunc(old, cur interface{}) {
+	c.addFunc(cur)
+}
+
+func (c *challengeCache) deleteFunc(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+
+	secret, ok := obj.(*api.Secret)
+
+	if !ok {
+		glog.Errorf("Expected object of type Secret")
+		return
+	}
+
+	c.remove(secret.Name)
+}
+
+func (c *challengeCache) remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.secrets, name)
+}
+
+type bySeen []challenge
+
+func (s bySeen) Len() int      { return len(s) }
+func (s bySeen) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySeen) Less(i, j int) bool {
+	if !s[i].seen.Equal(s[j].seen) {
+		return s[i].seen.Before(s[j].seen)
+	}
+	return s[i].Token < s[j].Token
+}
@@ -0,0 +1,83 @@
+package serve
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func challengeSecret(name string, keys map[string]string) *api.Secret {
+	s := &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "acme"},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range keys {
+		s.Data[k] = []byte(v)
+	}
+	return s
+}
+
+func TestChallengeTypes(t *testing.T) {
+	challenges = newChallengeCache()
+	challenges.addFunc(challengeSecret("example.com-acme", map[string]string{
+		acmeimpl.ChallengeKey(acme.HTTP01, "canary"):   "canary.kube-acme-self-check",
+		acmeimpl.ChallengeKey(acme.DNS01, "dnstoken"):  "dnstoken.thumbprint",
+		acmeimpl.ChallengeKey(acmeimpl.TLSALPN01, "a"): "a.thumbprint",
+	}))
+
+	r := mux.NewRouter()
+	r.HandleFunc("/.well-known/acme-challenge/{key}", HandleChallenge)
+
+	for url, expected := range map[string]int{
+		"/.well-known/acme-challenge/canary":   http.StatusOK,
+		"/.well-known/acme-challenge/dnstoken": http.StatusNotFound,
+		"/.well-known/acme-challenge/a":        http.StatusNotFound,
+		"/.well-known/acme-challenge/unknown":  http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com"+url, nil)
+		r.ServeHTTP(w, req)
+
+		if w.Code != expected {
+			t.Errorf("expected status %d for %s, got %d", expected, url, w.Code)
+		}
+	}
+
+	hello := &tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{acmeimpl.ACMETLS1Protocol}}
+
+	// only the tls-alpn-01 challenge may be answered over tls, even though
+	// the self check canary is newer
+	challenges.addFunc(challengeSecret("example.com-acme", map[string]string{
+		acmeimpl.ChallengeKey(acmeimpl.TLSALPN01, "a"): "a.thumbprint",
+		acmeimpl.ChallengeKey(acme.HTTP01, "canary2"):  "canary2.kube-acme-self-check",
+	}))
+
+	chs := challenges.list("example.com-acme", acmeimpl.TLSALPN01)
+
+	if len(chs) != 1 || chs[0].KeyAuth != "a.thumbprint" {
+		t.Errorf("expected only the tls-alpn-01 challenge, got %v", chs)
+	}
+
+	if _, err := GetChallengeCertificate(hello); err != nil {
+		t.Errorf("error getting challenge certificate: %s", err.Error())
+	}
+
+	challenges.addFunc(challengeSecret("example.com-acme", map[string]string{
+		acmeimpl.ChallengeKey(acme.HTTP01, "canary2"): "canary2.kube-acme-self-check",
+	}))
+
+	if _, err := GetChallengeCertificate(hello); err == nil {
+		t.Errorf("expected no certificate without a tls-alpn-01 challenge")
+	}
+
+	if records := dnsChallengeRecords("example.com"); len(records) != 0 {
+		t.Errorf("expected no dns records without a dns-01 challenge, got %v", records)
+	}
+}
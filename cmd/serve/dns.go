@@ -0,0 +1,86 @@
+package serve
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/miekg/dns"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+)
+
+var (
+	dnsListenAddr = flag.String("dnsListenAddr", "", "if set, the address to answer dns-01 challenge queries on over udp and tcp, e.g. 0.0.0.0:53")
+)
+
+const challengePrefix = "_acme-challenge."
+
+// serveDNS runs an authoritative dns server for _acme-challenge records on
+// addr, over both udp and tcp
+func serveDNS(addr string) {
+	handler := dns.HandlerFunc(HandleDNSChallenge)
+
+	for _, proto := range []string{"udp", "tcp"} {
+		go func(proto string) {
+			srv := &dns.Server{Addr: addr, Net: proto, Handler: handler}
+			glog.Fatalln(srv.ListenAndServe())
+		}(proto)
+	}
+}
+
+// HandleDNSChallenge answers TXT queries for _acme-challenge.<host> with the
+// dns-01 records for any challenges presented for host, or the wildcard of
+// host
+func HandleDNSChallenge(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	defer w.WriteMsg(m)
+
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+
+	if !strings.HasPrefix(name, challengePrefix) {
+		m.Rcode = dns.RcodeNameError
+		return
+	}
+
+	domain := strings.TrimSuffix(strings.TrimPrefix(name, challengePrefix), ".")
+
+	records := dnsChallengeRecords(domain)
+
+	if len(records) == 0 {
+		m.Rcode = dns.RcodeNameError
+		return
+	}
+
+	if q.Qtype != dns.TypeTXT && q.Qtype != dns.TypeANY {
+		return
+	}
+
+	m.Answer = records
+}
+
+func dnsChallengeRecords(domain string) []dns.RR {
+	var records []dns.RR
+
+	for _, d := range []string{domain, "*." + domain} {
+		for _, ch := range challenges.list(acmeimpl.ChallengeSecretName(d), acme.DNS01) {
+			fqdn, value, ttl := acme.DNS01Record(domain, ch.KeyAuth)
+
+			records = append(records, &dns.TXT{
+				Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(ttl)},
+				Txt: []string{value},
+			})
+		}
+	}
+
+	return records
+}
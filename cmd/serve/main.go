@@ -3,24 +3,33 @@ package serve
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/munnerz/kube-acme/pkg/watcher"
 	"github.com/namsral/flag"
+	"github.com/xenolf/lego/acme"
+	"golang.org/x/net/context"
 
-	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
 )
 
 var (
 	listenAddr = flag.String("listenAddr", "0.0.0.0:12000", "the address to listen on for incoming http requests")
 
-	kubeClient *client.Client
+	// challenges is kept up to date with the challenges presented in the acme
+	// namespace by a secret informer
+	challenges = newChallengeCache()
 )
 
 func Main(proxyURL *string) {
 	flag.Parse()
 
+	var kubeClient *client.Client
+
 	if *proxyURL != "" {
 		kubeClient = client.NewOrDie(&client.Config{
 			Host: *proxyURL,
@@ -33,6 +42,32 @@ func Main(proxyURL *string) {
 		}
 	}
 
+	w, err := watcher.New(kubeClient, "acme")
+
+	if err != nil {
+		glog.Fatalf("error launching apiserver watcher: %s", err.Error())
+	}
+
+	ctx, _ := context.WithCancel(context.Background())
+
+	// challenges are only stored in the lock secrets, and the account keys
+	// and ledger in the acme namespace are kept out of this process
+	lockSecrets := labels.SelectorFromSet(labels.Set{"acme-lock": "true"})
+
+	go w.WatchSecrets(ctx, time.Minute, lockSecrets, watcher.ChangeFuncs{
+		AddFunc:    challenges.addFunc,
+		UpdateFunc: challenges.updateFunc,
+		DeleteFunc: challenges.deleteFunc,
+	})
+
+	if len(*dnsListenAddr) > 0 {
+		serveDNS(*dnsListenAddr)
+	}
+
+	if len(*tlsListenAddr) > 0 {
+		serveTLSALPN(*tlsListenAddr)
+	}
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/.well-known/acme-challenge/{key}", HandleChallenge)
@@ -45,34 +80,19 @@ func HandleRedirect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("https://%s%s", r.Host, r.RequestURI), 301)
 }
 
+// HandleChallenge serves the key authorization for the token in the request
+// uri, if an http-01 challenge with that token has been presented for the host
 func HandleChallenge(w http.ResponseWriter, r *http.Request) {
-	// TODO: Make use of key in request URI
+	token := mux.Vars(r)["key"]
 
-	glog.Errorf("Req from: %s", r.Host)
-	res, err := kubeClient.RESTClient.Get().
-		Namespace("acme").
-		Resource("secrets").
-		Name(fmt.Sprintf("%s-acme", r.Host)).
-		Do().
-		Get()
+	glog.Infof("Req from: %s for token %s", r.Host, token)
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
-	}
+	ch, ok := challenges.get(acmeimpl.ChallengeSecretName(r.Host), acme.HTTP01, token)
 
-	if sec, ok := res.(*api.Secret); !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+	if !ok {
+		http.NotFound(w, r)
 		return
-	} else {
-		if challenge, ok := sec.Data["acme-auth"]; !ok {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
-		} else {
-			w.Write(challenge)
-		}
 	}
+
+	w.Write([]byte(ch.KeyAuth))
 }
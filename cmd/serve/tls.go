@@ -0,0 +1,85 @@
+package serve
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/namsral/flag"
+)
+
+var (
+	tlsListenAddr = flag.String("tlsListenAddr", "", "if set, the address to answer tls-alpn-01 challenges on, e.g. 0.0.0.0:12443")
+)
+
+// serveTLSALPN accepts tls connections on addr, completing acme-tls/1
+// handshakes with the validation certificate for the requested host
+func serveTLSALPN(addr string) {
+	l, err := tls.Listen("tcp", addr, &tls.Config{
+		NextProtos:     []string{acmeimpl.ACMETLS1Protocol},
+		GetCertificate: GetChallengeCertificate,
+	})
+
+	if err != nil {
+		glog.Fatalf("error listening for tls connections: %s", err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				glog.Fatalf("error accepting tls connection: %s", err.Error())
+			}
+
+			go handleTLSConn(conn)
+		}
+	}()
+}
+
+// handleTLSConn completes the handshake, which is all the acme server needs,
+// then closes the connection
+func handleTLSConn(conn net.Conn) {
+	defer conn.Close()
+
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		glog.Errorf("tls handshake from %s failed: %s", conn.RemoteAddr(), err.Error())
+	}
+}
+
+// GetChallengeCertificate returns the tls-alpn-01 validation certificate for
+// the server name in hello, if a challenge has been presented for it
+func GetChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if !supportsACMETLS1(hello.SupportedProtos) {
+		return nil, fmt.Errorf("client does not support %s", acmeimpl.ACMETLS1Protocol)
+	}
+
+	glog.Infof("tls-alpn-01 req for: %s", hello.ServerName)
+
+	chs := challenges.list(acmeimpl.ChallengeSecretName(hello.ServerName), acmeimpl.TLSALPN01)
+
+	if len(chs) == 0 {
+		return nil, fmt.Errorf("no %s challenge found for '%s'", acmeimpl.TLSALPN01, hello.ServerName)
+	}
+
+	// the handshake does not say which challenge is being validated, so
+	// present the most recent tls-alpn-01 one
+	cert, err := acmeimpl.TLSALPN01ChallengeCert(hello.ServerName, chs[len(chs)-1].KeyAuth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+func supportsACMETLS1(protos []string) bool {
+	for _, p := range protos {
+		if p == acmeimpl.ACMETLS1Protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -1,48 +1,178 @@
 package acmeimpl
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/xenolf/lego/acme"
 
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 )
 
 type Interface interface {
+	// Perform obtains a certificate for the request
 	Perform(*CertificateRequest) (*acme.CertificateResource, error)
+	// RevokeCertificate revokes a PEM encoded certificate
+	RevokeCertificate(certPEM []byte) error
+	// Server returns the directory url of the acme server
+	Server() string
 }
 
 type AcmeImpl struct {
-	*acme.Client
+	server     string
+	user       User
 	kubeClient *client.Client
+	keyType    acme.KeyType
+
+	secretsProviders map[acme.Challenge]acme.ChallengeProvider
+	dnsProviders     DNSProviders
+	selfCheck        *HTTP01SelfCheck
+
+	lock   sync.Mutex
+	client *Client
 }
 
-func (a *AcmeImpl) Perform(cr *CertificateRequest) (acme.CertificateResource, error) {
-	if cr.IsRenewal {
-		return a.RenewCertificate(cr.ExistingResource, true)
+var _ Interface = &AcmeImpl{}
+
+// Perform obtains a certificate for the hosts in cr. Renewals are new orders
+// for the same hosts, reusing the existing private key if one is given.
+func (a *AcmeImpl) Perform(cr *CertificateRequest) (*acme.CertificateResource, error) {
+	privKey := cr.PrivateKey
+
+	if privKey == nil {
+		keyType := cr.KeyType
+
+		if len(keyType) == 0 {
+			keyType = a.keyType
+		}
+
+		var err error
+		privKey, err = generatePrivateKey(keyType)
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	res, errs := a.ObtainCertificate(cr.Hosts, true, cr.PrivateKey)
+	providers, err := a.challengeProviders(cr)
 
-	return res, mapErrsToErr(errs)
-}
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := providers[acme.HTTP01]; ok && cr.SelfCheck {
+		for _, h := range cr.Hosts {
+			if err := a.selfCheck.Check(h, p); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-func NewAcmeImpl(kubeClient *client.Client, server string, user User, rsaKeySize acme.KeyType) (*AcmeImpl, error) {
-	client, err := acme.NewClient(server, &user, rsaKeySize)
+	c, err := a.Client()
 
 	if err != nil {
 		return nil, err
 	}
 
-	sp, err := NewSecretsProvider(kubeClient, "acme")
+	return c.ObtainCertificate(cr.Hosts, privKey, cr.CSR, providers)
+}
+
+// Server returns the directory url of the acme server
+func (a *AcmeImpl) Server() string {
+	return a.server
+}
+
+// Client returns the client for the acme server, fetching its directory and
+// registering the account on first use
+func (a *AcmeImpl) Client() (*Client, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	c, err := NewClient(a.server, a.user)
 
 	if err != nil {
 		return nil, err
 	}
 
-	client.ExcludeChallenges([]acme.Challenge{acme.TLSSNI01, acme.DNS01})
-	client.SetChallengeProvider(acme.HTTP01, sp)
+	// registering an existing account returns it as is, and gives us the
+	// account url used to sign requests
+	if _, err := c.Register(); err != nil {
+		return nil, wrapError(err, "error registering account with '%s'", a.server)
+	}
+
+	a.client = c
+
+	return c, nil
+}
+
+// RevokeCertificate revokes the PEM encoded certificate with the acme server
+func (a *AcmeImpl) RevokeCertificate(certPEM []byte) error {
+	c, err := a.Client()
+
+	if err != nil {
+		return err
+	}
+
+	return c.RevokeCertificate(certPEM)
+}
+
+// challengeProviders returns the providers to solve the challenge type
+// requested by cr with
+func (a *AcmeImpl) challengeProviders(cr *CertificateRequest) (map[acme.Challenge]acme.ChallengeProvider, error) {
+	if cr.ChallengeType != acme.DNS01 {
+		for _, h := range cr.Hosts {
+			if IsWildcard(h) {
+				return nil, fmt.Errorf("wildcard domain '%s' can only be validated with %s", h, acme.DNS01)
+			}
+		}
+	}
+
+	switch cr.ChallengeType {
+	case "", acme.HTTP01:
+		return map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: a.secretsProviders[acme.HTTP01]}, nil
+	case TLSALPN01:
+		return map[acme.Challenge]acme.ChallengeProvider{TLSALPN01: a.secretsProviders[TLSALPN01]}, nil
+	case acme.DNS01:
+		p, err := a.dnsProviders.Get(cr.DNSProvider)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return map[acme.Challenge]acme.ChallengeProvider{acme.DNS01: p}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported challenge type '%s'", cr.ChallengeType)
+}
+
+// NewAcmeImpl returns an AcmeImpl for user on the acme server with directory
+// url server. The server is not contacted until the first certificate is
+// requested, or Client is called.
+func NewAcmeImpl(kubeClient *client.Client, server string, user User, keyType acme.KeyType, dnsProviders DNSProviders) (*AcmeImpl, error) {
+	sps := make(map[acme.Challenge]acme.ChallengeProvider)
+
+	for _, t := range []acme.Challenge{acme.HTTP01, TLSALPN01} {
+		sp, err := NewSecretsProvider(kubeClient, "acme", t)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sps[t] = sp
+	}
 
 	return &AcmeImpl{
-		Client:     client,
-		kubeClient: kubeClient,
+		server:           server,
+		user:             user,
+		kubeClient:       kubeClient,
+		keyType:          keyType,
+		secretsProviders: sps,
+		dnsProviders:     dnsProviders,
+		selfCheck:        NewHTTP01SelfCheck(3, time.Second*2),
 	}, nil
 }
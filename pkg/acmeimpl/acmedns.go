@@ -0,0 +1,190 @@
+package acmeimpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// AcmeDNSProvider presents dns-01 challenges by updating the TXT record of
+// an account on an acme-dns server, that _acme-challenge.<domain> has been
+// CNAMEd to. An account is registered for each domain on first use and its
+// credentials are stored in a secret.
+type AcmeDNSProvider struct {
+	server     string
+	secrets    client.SecretsNamespacer
+	namespace  string
+	httpClient *http.Client
+
+	// LookupCNAME resolves the canonical name of a host, and can be
+	// replaced to avoid depending on the system resolver
+	LookupCNAME func(host string) (string, error)
+}
+
+type acmeDNSAccount struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	FullDomain string `json:"fulldomain"`
+	SubDomain  string `json:"subdomain"`
+}
+
+type acmeDNSUpdate struct {
+	SubDomain string `json:"subdomain"`
+	TXT       string `json:"txt"`
+}
+
+func (p *AcmeDNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := dns01Record(domain, keyAuth)
+	base := strings.TrimPrefix(domain, "*.")
+
+	acc, err := p.account(base)
+
+	if err != nil {
+		return err
+	}
+
+	cname, err := p.LookupCNAME(fqdn)
+
+	if err != nil || !strings.EqualFold(dns.Fqdn(cname), dns.Fqdn(acc.FullDomain)) {
+		return fmt.Errorf("'%s' must be a CNAME to '%s' before it can be validated using acme-dns", fqdn, acc.FullDomain)
+	}
+
+	body, err := json.Marshal(acmeDNSUpdate{SubDomain: acc.SubDomain, TXT: value})
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.server+"/update", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-User", acc.Username)
+	req.Header.Set("X-Api-Key", acc.Password)
+
+	if _, err := p.do(req); err != nil {
+		return fmt.Errorf("error updating acme-dns record for '%s': %s", domain, err.Error())
+	}
+
+	return nil
+}
+
+// CleanUp does nothing, as acme-dns only ever serves the most recent records
+func (p *AcmeDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+// account returns the acme-dns account for domain from its secret,
+// registering a new one if it does not exist yet
+func (p *AcmeDNSProvider) account(domain string) (*acmeDNSAccount, error) {
+	name := fmt.Sprintf("%s-acmedns", domain)
+
+	secret, err := p.secrets.Secrets(p.namespace).Get(name)
+
+	if err == nil {
+		return &acmeDNSAccount{
+			Username:   string(secret.Data["username"]),
+			Password:   string(secret.Data["password"]),
+			FullDomain: string(secret.Data["fulldomain"]),
+			SubDomain:  string(secret.Data["subdomain"]),
+		}, nil
+	}
+
+	if !kerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting acme-dns account for '%s': %s", domain, err.Error())
+	}
+
+	req, err := http.NewRequest("POST", p.server+"/register", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("error registering acme-dns account for '%s': %s", domain, err.Error())
+	}
+
+	acc := new(acmeDNSAccount)
+
+	if err := json.Unmarshal(body, acc); err != nil {
+		return nil, fmt.Errorf("error reading acme-dns account for '%s': %s", domain, err.Error())
+	}
+
+	secret = &api.Secret{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: api.ObjectMeta{
+			Name:      name,
+			Namespace: p.namespace,
+		},
+		Data: map[string][]byte{
+			"username":   []byte(acc.Username),
+			"password":   []byte(acc.Password),
+			"fulldomain": []byte(acc.FullDomain),
+			"subdomain":  []byte(acc.SubDomain),
+		},
+	}
+
+	if _, err := p.secrets.Secrets(p.namespace).Create(secret); err != nil {
+		return nil, fmt.Errorf("error saving acme-dns account for '%s': %s", domain, err.Error())
+	}
+
+	return acc, nil
+}
+
+func (p *AcmeDNSProvider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.httpClient.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// NewAcmeDNSProvider returns a provider using the acme-dns server at server,
+// storing account credentials in secrets in namespace ns
+func NewAcmeDNSProvider(secrets client.SecretsNamespacer, server, ns string) (*AcmeDNSProvider, error) {
+	if len(server) == 0 {
+		return nil, fmt.Errorf("acme-dns server must be set")
+	}
+
+	return &AcmeDNSProvider{
+		server:      strings.TrimSuffix(server, "/"),
+		secrets:     secrets,
+		namespace:   ns,
+		httpClient:  &http.Client{Timeout: time.Second * 30},
+		LookupCNAME: net.LookupCNAME,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package acmeimpl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/munnerz/kube-acme/pkg/kubetest"
+)
+
+// fakeAcmeDNS is an httptest stand-in for an acme-dns server
+type fakeAcmeDNS struct {
+	lock          sync.Mutex
+	registrations int
+	updates       []*http.Request
+	txt           map[string]string
+}
+
+func (f *fakeAcmeDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch r.URL.Path {
+	case "/register":
+		f.registrations++
+		json.NewEncoder(w).Encode(acmeDNSAccount{
+			Username:   "user",
+			Password:   "pass",
+			FullDomain: "d420c923.auth.example.org",
+			SubDomain:  "d420c923",
+		})
+	case "/update":
+		f.updates = append(f.updates, r)
+
+		if r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var u acmeDNSUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.txt[u.SubDomain] = u.TXT
+		json.NewEncoder(w).Encode(u)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestAcmeDNSProvider(t *testing.T, cname string) (*AcmeDNSProvider, *fakeAcmeDNS, *kubetest.Secrets, func()) {
+	f := &fakeAcmeDNS{txt: map[string]string{}}
+	srv := httptest.NewServer(f)
+	secrets := kubetest.NewSecrets()
+
+	p, err := NewAcmeDNSProvider(secrets, srv.URL+"/", "acme")
+
+	if err != nil {
+		t.Fatalf("error creating provider: %s", err.Error())
+	}
+
+	p.LookupCNAME = func(host string) (string, error) {
+		return cname, nil
+	}
+
+	return p, f, secrets, srv.Close
+}
+
+func TestAcmeDNSPresent(t *testing.T) {
+	p, f, secrets, stop := newTestAcmeDNSProvider(t, "d420c923.auth.example.org.")
+	defer stop()
+
+	if err := p.Present("*.example.com", "token", "token.thumbprint"); err != nil {
+		t.Fatalf("error presenting challenge: %s", err.Error())
+	}
+
+	secret, err := secrets.Secrets("acme").Get("example.com-acmedns")
+
+	if err != nil {
+		t.Fatalf("expected account to be stored in a secret: %s", err.Error())
+	}
+
+	for k, v := range map[string]string{"username": "user", "password": "pass", "fulldomain": "d420c923.auth.example.org", "subdomain": "d420c923"} {
+		if string(secret.Data[k]) != v {
+			t.Errorf("expected secret key '%s' to be '%s', got '%s'", k, v, secret.Data[k])
+		}
+	}
+
+	_, value, _ := dns01Record("*.example.com", "token.thumbprint")
+
+	if f.txt["d420c923"] != value {
+		t.Errorf("expected TXT record to be updated to '%s', got '%s'", value, f.txt["d420c923"])
+	}
+
+	if len(f.updates) != 1 {
+		t.Fatalf("expected 1 update request, got %d", len(f.updates))
+	}
+
+	if ct := f.updates[0].Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected update Content-Type application/json, got '%s'", ct)
+	}
+
+	// the stored account is reused
+	if err := p.Present("example.com", "token2", "token2.thumbprint"); err != nil {
+		t.Fatalf("error presenting second challenge: %s", err.Error())
+	}
+
+	if f.registrations != 1 {
+		t.Errorf("expected 1 registration, got %d", f.registrations)
+	}
+}
+
+func TestAcmeDNSCNAMEMismatch(t *testing.T) {
+	p, f, _, stop := newTestAcmeDNSProvider(t, "_acme-challenge.example.com.")
+	defer stop()
+
+	err := p.Present("example.com", "token", "token.thumbprint")
+
+	if err == nil {
+		t.Fatalf("expected an error when the challenge record is not a CNAME to acme-dns")
+	}
+
+	if !strings.Contains(err.Error(), "must be a CNAME to 'd420c923.auth.example.org'") {
+		t.Errorf("expected the error to name the CNAME target, got: %s", err.Error())
+	}
+
+	if len(f.updates) != 0 {
+		t.Errorf("expected no update requests, got %d", len(f.updates))
+	}
+}
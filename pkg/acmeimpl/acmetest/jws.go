@@ -0,0 +1,123 @@
+package acmetest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	// register the hash functions used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/square/go-jose"
+)
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsHeader struct {
+	Alg   string           `json:"alg"`
+	Nonce string           `json:"nonce"`
+	URL   string           `json:"url"`
+	Kid   string           `json:"kid"`
+	JWK   *jose.JsonWebKey `json:"jwk"`
+}
+
+// parseJWS decodes a flattened JSON web signature, returning its header and
+// payload and the signing input and signature to verify it with
+func parseJWS(body []byte) (*jwsHeader, []byte, []byte, []byte, error) {
+	var msg jwsMessage
+
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("error decoding jws: %s", err.Error())
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("error decoding protected header: %s", err.Error())
+	}
+
+	var header jwsHeader
+
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("error decoding protected header: %s", err.Error())
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("error decoding payload: %s", err.Error())
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("error decoding signature: %s", err.Error())
+	}
+
+	return &header, payload, []byte(msg.Protected + "." + msg.Payload), sig, nil
+}
+
+// verifySignature checks sig over input was made by key with alg
+func verifySignature(key crypto.PublicKey, alg string, input, sig []byte) error {
+	var hash crypto.Hash
+
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "ES384":
+		hash = crypto.SHA384
+	default:
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("algorithm '%s' does not match rsa key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		if len(sig) != size*2 {
+			return fmt.Errorf("invalid signature length %d", len(sig))
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// thumbprint returns the base64url encoded RFC 7638 thumbprint of key
+func thumbprint(key crypto.PublicKey) (string, error) {
+	jwk := jose.JsonWebKey{Key: key}
+
+	thumb, err := jwk.Thumbprint(crypto.SHA256)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumb), nil
+}
@@ -0,0 +1,741 @@
+// Package acmetest provides an in-process RFC 8555 acme server, for testing
+// acme clients without a real certificate authority
+package acmetest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints that errors can be injected in to with InjectError
+const (
+	EndpointNewNonce   = "new-nonce"
+	EndpointNewAccount = "new-account"
+	EndpointNewOrder   = "new-order"
+	EndpointRevokeCert = "revoke-cert"
+	EndpointOrder      = "order"
+	EndpointAuthz      = "authz"
+	EndpointChallenge  = "challenge"
+	EndpointFinalize   = "finalize"
+	EndpointCert       = "cert"
+)
+
+const problemPrefix = "urn:ietf:params:acme:error:"
+
+// Problem is an RFC 7807 problem document returned by the server
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+// HTTP01Fetcher returns the body served at
+// http://<domain>/.well-known/acme-challenge/<token>
+type HTTP01Fetcher func(domain, token string) (string, error)
+
+// DNS01Fetcher returns the TXT records of name
+type DNS01Fetcher func(name string) ([]string, error)
+
+// Server is an in-process acme server. Challenges are validated as soon as
+// they are accepted, using the HTTP01 and DNS01 fetchers, and certificates
+// are issued from a throwaway CA.
+type Server struct {
+	// URL is the url of the acme directory
+	URL string
+
+	// HTTP01 and DNS01 fetch challenge responses. By default they fetch over
+	// http and look up TXT records with the system resolver.
+	HTTP01 HTTP01Fetcher
+	DNS01  DNS01Fetcher
+
+	// CertificateValidity is how long issued certificates are valid for
+	CertificateValidity time.Duration
+
+	srv *httptest.Server
+	ca  *ca
+
+	lock       sync.Mutex
+	nextID     int
+	nonces     map[string]bool
+	accounts   map[string]*account
+	orders     map[string]*order
+	authzs     map[string]*authz
+	challenges map[string]*challenge
+	certs      map[string][]byte
+	revoked    map[string]bool
+	injected   map[string][]Problem
+}
+
+type account struct {
+	URL        string           `json:"-"`
+	Key        crypto.PublicKey `json:"-"`
+	Thumbprint string           `json:"-"`
+
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	URL     string `json:"-"`
+	Account string `json:"-"`
+
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+type authz struct {
+	URL     string `json:"-"`
+	Account string `json:"-"`
+
+	Identifier identifier   `json:"identifier"`
+	Status     string       `json:"status"`
+	Expires    string       `json:"expires"`
+	Challenges []*challenge `json:"challenges"`
+	Wildcard   bool         `json:"wildcard,omitempty"`
+}
+
+type challenge struct {
+	authz *authz
+
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Status string   `json:"status"`
+	Token  string   `json:"token"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// NewServer starts a new acme server listening on a random local port
+func NewServer() (*Server, error) {
+	ca, err := newCA()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		HTTP01:              fetchHTTP01,
+		DNS01:               net.LookupTXT,
+		CertificateValidity: time.Hour * 24 * 90,
+		ca:                  ca,
+		nonces:              map[string]bool{},
+		accounts:            map[string]*account{},
+		orders:              map[string]*order{},
+		authzs:              map[string]*authz{},
+		challenges:          map[string]*challenge{},
+		certs:               map[string][]byte{},
+		revoked:             map[string]bool{},
+		injected:            map[string][]Problem{},
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL + "/directory"
+
+	return s, nil
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// CACertificate returns the certificate of the CA that signs all issued
+// certificates
+func (s *Server) CACertificate() *x509.Certificate {
+	return s.ca.cert
+}
+
+// InjectError makes the next request to endpoint fail with a problem of the
+// given type and http status. problemType may be the full urn or just the
+// name, e.g. 'rateLimited'. Errors injected for the same endpoint are
+// returned in order.
+func (s *Server) InjectError(endpoint string, status int, problemType, detail string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !strings.HasPrefix(problemType, "urn:") {
+		problemType = problemPrefix + problemType
+	}
+
+	s.injected[endpoint] = append(s.injected[endpoint], Problem{Type: problemType, Detail: detail, Status: status})
+}
+
+// Revoked returns true if the certificate with the given serial number has
+// been revoked
+func (s *Server) Revoked(serial string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.revoked[serial]
+}
+
+func (s *Server) url(endpoint string, id int) string {
+	if id == 0 {
+		return fmt.Sprintf("%s/%s", s.srv.URL, endpoint)
+	}
+	return fmt.Sprintf("%s/%s/%d", s.srv.URL, endpoint, id)
+}
+
+func (s *Server) id() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) nonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	n := base64.RawURLEncoding.EncodeToString(b)
+	s.nonces[n] = true
+	return n
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	w.Header().Set("Replay-Nonce", s.nonce())
+
+	if r.URL.Path == "/directory" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   s.url(EndpointNewNonce, 0),
+			"newAccount": s.url(EndpointNewAccount, 0),
+			"newOrder":   s.url(EndpointNewOrder, 0),
+			"revokeCert": s.url(EndpointRevokeCert, 0),
+			"keyChange":  s.url("key-change", 0),
+			"meta": map[string]string{
+				"termsOfService": s.srv.URL + "/terms",
+			},
+		})
+		return
+	}
+
+	endpoint := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+
+	if ps := s.injected[endpoint]; len(ps) > 0 {
+		s.injected[endpoint] = ps[1:]
+		writeProblem(w, ps[0])
+		return
+	}
+
+	if endpoint == EndpointNewNonce {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		writeProblem(w, problem(http.StatusMethodNotAllowed, "malformed", "%s requests must be POSTed", endpoint))
+		return
+	}
+
+	url := s.srv.URL + r.URL.Path
+	acc, payload, p := s.verify(r, url, endpoint == EndpointNewAccount)
+
+	if p != nil {
+		writeProblem(w, *p)
+		return
+	}
+
+	switch endpoint {
+	case EndpointNewAccount:
+		s.newAccount(w, acc, payload)
+	case EndpointNewOrder:
+		s.newOrder(w, acc, payload)
+	case EndpointOrder:
+		s.getOrder(w, acc, url)
+	case EndpointAuthz:
+		s.getAuthz(w, acc, url)
+	case EndpointChallenge:
+		s.acceptChallenge(w, acc, url)
+	case EndpointFinalize:
+		s.finalize(w, acc, url, payload)
+	case EndpointCert:
+		s.getCert(w, acc, url)
+	case EndpointRevokeCert:
+		s.revokeCert(w, payload)
+	default:
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "unknown endpoint %s", endpoint))
+	}
+}
+
+// verify checks the signature, nonce and url of the JWS in the body of r. New
+// accounts are signed with a jwk, which is returned as an account without an
+// url, and all other requests must be signed by an existing account.
+func (s *Server) verify(r *http.Request, url string, newAccount bool) (*account, []byte, *Problem) {
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		p := problem(http.StatusBadRequest, "malformed", "%s", err.Error())
+		return nil, nil, &p
+	}
+
+	header, payload, input, sig, err := parseJWS(body)
+
+	if err != nil {
+		p := problem(http.StatusBadRequest, "malformed", "%s", err.Error())
+		return nil, nil, &p
+	}
+
+	if !s.nonces[header.Nonce] {
+		p := problem(http.StatusBadRequest, "badNonce", "invalid nonce '%s'", header.Nonce)
+		return nil, nil, &p
+	}
+
+	delete(s.nonces, header.Nonce)
+
+	if header.URL != url {
+		p := problem(http.StatusUnauthorized, "unauthorized", "jws url '%s' does not match request url '%s'", header.URL, url)
+		return nil, nil, &p
+	}
+
+	var acc *account
+
+	switch {
+	case newAccount && header.JWK != nil:
+		acc = &account{Key: header.JWK.Key}
+	case !newAccount && len(header.Kid) > 0:
+		if acc = s.accounts[header.Kid]; acc == nil {
+			p := problem(http.StatusBadRequest, "accountDoesNotExist", "unknown account '%s'", header.Kid)
+			return nil, nil, &p
+		}
+	default:
+		p := problem(http.StatusBadRequest, "malformed", "requests must be signed with a jwk when creating an account, and a kid otherwise")
+		return nil, nil, &p
+	}
+
+	if err := verifySignature(acc.Key, header.Alg, input, sig); err != nil {
+		p := problem(http.StatusBadRequest, "malformed", "error verifying signature: %s", err.Error())
+		return nil, nil, &p
+	}
+
+	return acc, payload, nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, acc *account, payload []byte) {
+	var req struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "malformed", "%s", err.Error()))
+		return
+	}
+
+	thumb, err := thumbprint(acc.Key)
+
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "badPublicKey", "%s", err.Error()))
+		return
+	}
+
+	for _, existing := range s.accounts {
+		if existing.Thumbprint == thumb {
+			w.Header().Set("Location", existing.URL)
+			writeJSON(w, http.StatusOK, existing)
+			return
+		}
+	}
+
+	if req.OnlyReturnExisting {
+		writeProblem(w, problem(http.StatusBadRequest, "accountDoesNotExist", "no account exists for this key"))
+		return
+	}
+
+	if !req.TermsOfServiceAgreed {
+		writeProblem(w, problem(http.StatusForbidden, "userActionRequired", "the terms of service must be agreed to"))
+		return
+	}
+
+	acc.URL = s.url("account", s.id())
+	acc.Thumbprint = thumb
+	acc.Status = "valid"
+	acc.Contact = req.Contact
+	s.accounts[acc.URL] = acc
+
+	w.Header().Set("Location", acc.URL)
+	writeJSON(w, http.StatusCreated, acc)
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, acc *account, payload []byte) {
+	var req struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+		writeProblem(w, problem(http.StatusBadRequest, "malformed", "orders must have identifiers"))
+		return
+	}
+
+	expires := time.Now().Add(time.Hour * 24 * 7).UTC().Format(time.RFC3339)
+	id := s.id()
+
+	o := &order{
+		URL:         s.url(EndpointOrder, id),
+		Account:     acc.URL,
+		Status:      "pending",
+		Expires:     expires,
+		Identifiers: req.Identifiers,
+		Finalize:    s.url(EndpointFinalize, id),
+	}
+
+	for _, ident := range req.Identifiers {
+		if ident.Type != "dns" {
+			writeProblem(w, problem(http.StatusBadRequest, "unsupportedIdentifier", "unsupported identifier type '%s'", ident.Type))
+			return
+		}
+
+		a := &authz{
+			URL:        s.url(EndpointAuthz, s.id()),
+			Account:    acc.URL,
+			Identifier: ident,
+			Status:     "pending",
+			Expires:    expires,
+		}
+
+		types := []string{"http-01", "dns-01"}
+
+		if strings.HasPrefix(ident.Value, "*.") {
+			a.Identifier.Value = strings.TrimPrefix(ident.Value, "*.")
+			a.Wildcard = true
+			types = []string{"dns-01"}
+		}
+
+		for _, t := range types {
+			b := make([]byte, 32)
+			rand.Read(b)
+
+			ch := &challenge{
+				authz:  a,
+				Type:   t,
+				URL:    s.url(EndpointChallenge, s.id()),
+				Status: "pending",
+				Token:  base64.RawURLEncoding.EncodeToString(b),
+			}
+
+			a.Challenges = append(a.Challenges, ch)
+			s.challenges[ch.URL] = ch
+		}
+
+		s.authzs[a.URL] = a
+		o.Authorizations = append(o.Authorizations, a.URL)
+	}
+
+	s.orders[o.URL] = o
+
+	w.Header().Set("Location", o.URL)
+	writeJSON(w, http.StatusCreated, o)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, acc *account, url string) {
+	o, ok := s.orders[url]
+
+	if !ok || o.Account != acc.URL {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "no order at %s", url))
+		return
+	}
+
+	s.updateOrderStatus(o)
+	writeJSON(w, http.StatusOK, o)
+}
+
+// updateOrderStatus moves a pending order to ready or invalid once all of its
+// authorizations are valid or any are invalid
+func (s *Server) updateOrderStatus(o *order) {
+	if o.Status != "pending" {
+		return
+	}
+
+	ready := true
+
+	for _, u := range o.Authorizations {
+		switch s.authzs[u].Status {
+		case "valid":
+		case "invalid":
+			o.Status = "invalid"
+			o.Error = &Problem{Type: problemPrefix + "unauthorized", Detail: fmt.Sprintf("authorization %s failed", u), Status: http.StatusForbidden}
+			return
+		default:
+			ready = false
+		}
+	}
+
+	if ready {
+		o.Status = "ready"
+	}
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, acc *account, url string) {
+	a, ok := s.authzs[url]
+
+	if !ok || a.Account != acc.URL {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "no authorization at %s", url))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a)
+}
+
+// acceptChallenge validates the challenge straight away, marking it and its
+// authorization valid or invalid
+func (s *Server) acceptChallenge(w http.ResponseWriter, acc *account, url string) {
+	ch, ok := s.challenges[url]
+
+	if !ok || ch.authz.Account != acc.URL {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "no challenge at %s", url))
+		return
+	}
+
+	if ch.Status == "pending" {
+		keyAuth := ch.Token + "." + acc.Thumbprint
+
+		// the fetchers may block, so are called without holding the lock
+		s.lock.Unlock()
+		p := s.validate(ch, keyAuth)
+		s.lock.Lock()
+
+		if p != nil {
+			ch.Status, ch.Error = "invalid", p
+			ch.authz.Status = "invalid"
+		} else {
+			ch.Status = "valid"
+			ch.authz.Status = "valid"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ch)
+}
+
+func (s *Server) validate(ch *challenge, keyAuth string) *Problem {
+	domain := ch.authz.Identifier.Value
+
+	switch ch.Type {
+	case "http-01":
+		body, err := s.HTTP01(domain, ch.Token)
+
+		if err != nil {
+			p := problem(http.StatusBadRequest, "connection", "error fetching http-01 challenge for %s: %s", domain, err.Error())
+			return &p
+		}
+
+		if strings.TrimSpace(body) != keyAuth {
+			p := problem(http.StatusForbidden, "unauthorized", "invalid response from http-01 challenge for %s: %q", domain, body)
+			return &p
+		}
+	case "dns-01":
+		digest := sha256.Sum256([]byte(keyAuth))
+		want := base64.RawURLEncoding.EncodeToString(digest[:])
+		name := "_acme-challenge." + domain
+
+		txts, err := s.DNS01(name)
+
+		if err != nil {
+			p := problem(http.StatusBadRequest, "dns", "error looking up TXT records for %s: %s", name, err.Error())
+			return &p
+		}
+
+		for _, t := range txts {
+			if t == want {
+				return nil
+			}
+		}
+
+		p := problem(http.StatusForbidden, "unauthorized", "no matching TXT record found at %s", name)
+		return &p
+	}
+
+	return nil
+}
+
+func (s *Server) finalize(w http.ResponseWriter, acc *account, url string, payload []byte) {
+	o, ok := s.orders[strings.Replace(url, "/"+EndpointFinalize+"/", "/"+EndpointOrder+"/", 1)]
+
+	if !ok || o.Account != acc.URL {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "no order for %s", url))
+		return
+	}
+
+	s.updateOrderStatus(o)
+
+	if o.Status != "ready" {
+		writeProblem(w, problem(http.StatusForbidden, "orderNotReady", "order has status '%s'", o.Status))
+		return
+	}
+
+	var req struct {
+		CSR string `json:"csr"`
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "malformed", "%s", err.Error()))
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "badCSR", "%s", err.Error()))
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "badCSR", "%s", err.Error()))
+		return
+	}
+
+	if !sameNames(csr.DNSNames, o.Identifiers) {
+		writeProblem(w, problem(http.StatusBadRequest, "badCSR", "csr names %s do not match the order", csr.DNSNames))
+		return
+	}
+
+	chain, err := s.ca.issue(csr, s.CertificateValidity)
+
+	if err != nil {
+		writeProblem(w, problem(http.StatusInternalServerError, "serverInternal", "error issuing certificate: %s", err.Error()))
+		return
+	}
+
+	o.Status = "valid"
+	o.Certificate = s.url(EndpointCert, s.id())
+	s.certs[o.Certificate] = chain
+
+	w.Header().Set("Location", o.URL)
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) getCert(w http.ResponseWriter, acc *account, url string) {
+	chain, ok := s.certs[url]
+
+	if !ok {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "no certificate at %s", url))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(chain)
+}
+
+func (s *Server) revokeCert(w http.ResponseWriter, payload []byte) {
+	var req struct {
+		Certificate string `json:"certificate"`
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "malformed", "%s", err.Error()))
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(req.Certificate)
+
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, "malformed", "%s", err.Error()))
+		return
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil || cert.CheckSignatureFrom(s.ca.cert) != nil {
+		writeProblem(w, problem(http.StatusNotFound, "malformed", "certificate was not issued by this server"))
+		return
+	}
+
+	serial := cert.SerialNumber.String()
+
+	if s.revoked[serial] {
+		writeProblem(w, problem(http.StatusBadRequest, "alreadyRevoked", "certificate has already been revoked"))
+		return
+	}
+
+	s.revoked[serial] = true
+	w.WriteHeader(http.StatusOK)
+}
+
+func sameNames(names []string, idents []identifier) bool {
+	if len(names) != len(idents) {
+		return false
+	}
+
+	want := map[string]bool{}
+	for _, i := range idents {
+		want[strings.ToLower(i.Value)] = true
+	}
+
+	for _, n := range names {
+		if !want[strings.ToLower(n)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func problem(status int, problemType, format string, args ...interface{}) Problem {
+	return Problem{
+		Type:   problemPrefix + problemType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	body, _ := json.Marshal(p)
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	body, _ := json.Marshal(v)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func fetchHTTP01(domain, token string) (string, error) {
+	client := &http.Client{Timeout: time.Second * 10}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", domain, token))
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	return string(body), err
+}
@@ -0,0 +1,478 @@
+package acmeimpl
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/square/go-jose"
+	"github.com/xenolf/lego/acme"
+)
+
+const (
+	userAgent = "kube-acme"
+
+	// maxBadNonceRetries is the number of times a request is retried after
+	// the server rejects the nonce it was signed with
+	maxBadNonceRetries = 3
+
+	// pollTimeout is how long to wait for an authorization or order to
+	// leave the pending or processing state
+	pollTimeout = time.Minute * 2
+)
+
+// Client is an RFC 8555 (ACME v2) client for a single account on a single
+// acme server
+type Client struct {
+	httpClient *http.Client
+	dir        directory
+	jws        *jws
+	user       User
+}
+
+// NewClient creates a client for user against the acme directory at
+// directoryURL. Register must be called before any orders can be placed.
+func NewClient(directoryURL string, user User) (*Client, error) {
+	if user.GetPrivateKey() == nil {
+		return nil, errors.New("private key was nil")
+	}
+
+	httpClient := &http.Client{Timeout: time.Second * 30}
+
+	c := &Client{
+		httpClient: httpClient,
+		user:       user,
+	}
+
+	resp, body, err := c.get(directoryURL)
+
+	if err != nil {
+		return nil, wrapError(err, "error getting directory at '%s'", directoryURL)
+	}
+
+	if err := checkResponse(resp, body); err != nil {
+		return nil, wrapError(err, "error getting directory at '%s'", directoryURL)
+	}
+
+	if err := json.Unmarshal(body, &c.dir); err != nil {
+		return nil, fmt.Errorf("error reading directory at '%s': %s", directoryURL, err.Error())
+	}
+
+	if len(c.dir.NewNonce) == 0 || len(c.dir.NewAccount) == 0 || len(c.dir.NewOrder) == 0 {
+		return nil, fmt.Errorf("directory at '%s' is not an ACME v2 directory", directoryURL)
+	}
+
+	c.jws = &jws{
+		privKey: user.GetPrivateKey(),
+		nonces: &noncePool{
+			url:        c.dir.NewNonce,
+			httpClient: httpClient,
+		},
+	}
+
+	return c, nil
+}
+
+// Register creates the account on the acme server, agreeing to its terms of
+// service. If the account already exists it is returned as is. The returned
+// account url is used to sign all subsequent requests.
+func (c *Client) Register() (*acme.RegistrationResource, error) {
+	acc := account{
+		TermsOfServiceAgreed: true,
+	}
+
+	if email := c.user.GetEmail(); len(email) > 0 {
+		acc.Contact = []string{"mailto:" + email}
+	}
+
+	var res account
+	resp, err := c.postJSON(c.dir.NewAccount, acc, &res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	uri := resp.Header.Get("Location")
+
+	if len(uri) == 0 {
+		return nil, errors.New("server did not return an account url")
+	}
+
+	c.jws.kid = uri
+
+	return &acme.RegistrationResource{
+		URI:    uri,
+		TosURL: c.dir.Meta.TermsOfService,
+		Body: acme.Registration{
+			Key:     jose.JsonWebKey{Key: publicKey(c.user.GetPrivateKey())},
+			Contact: res.Contact,
+		},
+	}, nil
+}
+
+// ObtainCertificate places an order for domains, solves all of its
+// authorizations using the first offered challenge type found in providers
+// and finalizes it with a CSR for privKey built with opts. The first domain
+// is used as the common name of the certificate.
+func (c *Client) ObtainCertificate(domains []string, privKey crypto.PrivateKey, opts CSROptions, providers map[acme.Challenge]acme.ChallengeProvider) (*acme.CertificateResource, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("no domains to obtain a certificate for")
+	}
+
+	req := order{}
+	for _, d := range domains {
+		req.Identifiers = append(req.Identifiers, identifier{Type: "dns", Value: d})
+	}
+
+	var o order
+	resp, err := c.postJSON(c.dir.NewOrder, req, &o)
+
+	if err != nil {
+		return nil, wrapError(err, "error creating order")
+	}
+
+	orderURL := resp.Header.Get("Location")
+
+	if errs := c.solveAuthorizations(o.Authorizations, providers); len(errs) > 0 {
+		return nil, &AuthorizationError{Errors: errs}
+	}
+
+	csr, err := generateCSR(privKey, domains, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("error generating csr: %s", err.Error())
+	}
+
+	glog.Infof("[%s] acme: validations succeeded, finalizing order", domains[0])
+
+	err = c.poll(orderURL, &o, orderStatus(&o, "ready", "pending"))
+
+	if err != nil {
+		return nil, wrapError(err, "error waiting for order to become ready")
+	}
+
+	if _, err := c.postJSON(o.Finalize, finalizeRequest{CSR: base64.RawURLEncoding.EncodeToString(csr)}, &o); err != nil {
+		return nil, wrapError(err, "error finalizing order")
+	}
+
+	err = c.poll(orderURL, &o, orderStatus(&o, "valid", "processing"))
+
+	if err != nil {
+		return nil, wrapError(err, "error waiting for order to become valid")
+	}
+
+	resp, cert, err := c.post(o.Certificate, nil)
+
+	if err != nil {
+		return nil, wrapError(err, "error downloading certificate")
+	}
+
+	if err := checkResponse(resp, cert); err != nil {
+		return nil, wrapError(err, "error downloading certificate")
+	}
+
+	keyPem, err := EncodePEMPrivateKey(privKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &acme.CertificateResource{
+		Domain:        domains[0],
+		CertURL:       o.Certificate,
+		CertStableURL: o.Certificate,
+		AccountRef:    c.jws.kid,
+		PrivateKey:    keyPem,
+		Certificate:   cert,
+	}, nil
+}
+
+// orderStatus returns a poll func that completes once o has status want,
+// and keeps polling while it has status waiting
+func orderStatus(o *order, want, waiting string) func() (bool, error) {
+	return func() (bool, error) {
+		switch o.Status {
+		case want:
+			return true, nil
+		case waiting:
+			return false, nil
+		}
+		if o.Error != nil {
+			return false, o.Error
+		}
+		return false, fmt.Errorf("order has unexpected status '%s'", o.Status)
+	}
+}
+
+// RevokeCertificate revokes the first certificate in the PEM encoded bundle
+// cert
+func (c *Client) RevokeCertificate(cert []byte) error {
+	block, _ := pem.Decode(cert)
+
+	if block == nil {
+		return errors.New("no PEM data found in certificate")
+	}
+
+	_, err := c.postJSON(c.dir.RevokeCert, revokeRequest{Certificate: base64.RawURLEncoding.EncodeToString(block.Bytes)}, nil)
+
+	return err
+}
+
+// solveAuthorizations solves every pending authorization in authzURLs, in
+// series, returning any failures keyed by domain
+func (c *Client) solveAuthorizations(authzURLs []string, providers map[acme.Challenge]acme.ChallengeProvider) map[string]error {
+	failures := make(map[string]error)
+
+	for _, u := range authzURLs {
+		var authz authorization
+
+		if _, err := c.postJSON(u, nil, &authz); err != nil {
+			failures[u] = err
+			continue
+		}
+
+		if authz.Status == "valid" {
+			continue
+		}
+
+		if err := c.solveAuthorization(u, &authz, providers); err != nil {
+			domain := authz.Identifier.Value
+			if authz.Wildcard {
+				domain = "*." + domain
+			}
+			failures[domain] = err
+		}
+	}
+
+	return failures
+}
+
+func (c *Client) solveAuthorization(authzURL string, authz *authorization, providers map[acme.Challenge]acme.ChallengeProvider) error {
+	domain := authz.Identifier.Value
+
+	// wildcard authorizations are for the base domain, but providers are
+	// given the wildcard name so they can tell the two apart
+	if authz.Wildcard {
+		domain = "*." + domain
+	}
+
+	var chlng challenge
+	var provider acme.ChallengeProvider
+	for _, ch := range authz.Challenges {
+		if p, ok := providers[ch.Type]; ok {
+			chlng, provider = ch, p
+			break
+		}
+	}
+
+	if provider == nil {
+		return fmt.Errorf("no provider for any of the offered challenges")
+	}
+
+	keyAuth, err := c.jws.keyAuthorization(chlng.Token)
+
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("[%s] acme: presenting %s challenge", domain, chlng.Type)
+
+	if err := provider.Present(domain, chlng.Token, keyAuth); err != nil {
+		return fmt.Errorf("error presenting %s challenge: %s", chlng.Type, err.Error())
+	}
+
+	defer func() {
+		if err := provider.CleanUp(domain, chlng.Token, keyAuth); err != nil {
+			glog.Errorf("[%s] acme: error cleaning up %s challenge: %s", domain, chlng.Type, err.Error())
+		}
+	}()
+
+	if _, err := c.postJSON(chlng.URL, struct{}{}, nil); err != nil {
+		return wrapError(err, "error accepting %s challenge", chlng.Type)
+	}
+
+	return c.poll(authzURL, authz, func() (bool, error) {
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "pending":
+			return false, nil
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == chlng.Type && ch.Error != nil {
+				return false, &validationError{ch.Error}
+			}
+		}
+		return false, fmt.Errorf("authorization has status '%s'", authz.Status)
+	})
+}
+
+// poll fetches url in to res until done returns true or an error, honouring
+// any Retry-After returned by the server between fetches that are not final
+func (c *Client) poll(url string, res interface{}, done func() (bool, error)) error {
+	deadline := time.Now().Add(pollTimeout)
+
+	var resp *http.Response
+
+	for {
+		ok, err := done()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", url)
+		}
+
+		if resp != nil {
+			time.Sleep(retryAfter(resp))
+		}
+
+		resp, err = c.postJSON(url, nil, res)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// postJSON signs and posts reqBody to url, decoding the response in to
+// resBody if it is not nil. A nil reqBody sends a POST-as-GET request.
+func (c *Client) postJSON(url string, reqBody, resBody interface{}) (*http.Response, error) {
+	var payload []byte
+
+	if reqBody != nil {
+		var err error
+		payload, err = json.Marshal(reqBody)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, body, err := c.post(url, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(resp, body); err != nil {
+		return resp, err
+	}
+
+	if resBody != nil && len(body) > 0 {
+		if err := json.Unmarshal(body, resBody); err != nil {
+			return resp, fmt.Errorf("error reading response from %s: %s", url, err.Error())
+		}
+	}
+
+	return resp, nil
+}
+
+// post signs and posts payload to url, retrying if the server rejects the
+// nonce used
+func (c *Client) post(url string, payload []byte) (*http.Response, []byte, error) {
+	for i := 0; ; i++ {
+		signed, err := c.jws.sign(url, payload)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(signed))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/jose+json")
+
+		resp, body, err := c.do(req)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		c.jws.nonces.Add(resp)
+
+		if i < maxBadNonceRetries {
+			if p, ok := checkResponse(resp, body).(*Problem); ok && p.Type == ProblemBadNonce {
+				continue
+			}
+		}
+
+		return resp, body, nil
+	}
+}
+
+func (c *Client) get(url string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.httpClient.Do(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// checkResponse returns a *Problem if resp is an error response
+func checkResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	p := &Problem{}
+
+	if err := json.Unmarshal(body, p); err != nil || len(p.Type) == 0 {
+		return &Problem{
+			Detail: fmt.Sprintf("unexpected response status %d: %s", resp.StatusCode, string(body)),
+			Status: resp.StatusCode,
+		}
+	}
+
+	p.Status = resp.StatusCode
+
+	return p
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if ra, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && ra > 0 {
+		return time.Duration(ra) * time.Second
+	}
+	return time.Second * 2
+}
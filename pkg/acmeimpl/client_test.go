@@ -0,0 +1,251 @@
+package acmeimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl/acmetest"
+	"github.com/xenolf/lego/acme"
+)
+
+// recordingProvider records the key authorizations presented for each domain
+type recordingProvider struct {
+	lock     sync.Mutex
+	keyAuths map[string]string
+}
+
+func newRecordingProvider() *recordingProvider {
+	return &recordingProvider{keyAuths: map[string]string{}}
+}
+
+func (p *recordingProvider) Present(domain, token, keyAuth string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.keyAuths[domain] = keyAuth
+	return nil
+}
+
+func (p *recordingProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (p *recordingProvider) get(domain string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.keyAuths[domain]
+}
+
+// newTestClient returns a registered client for a new acmetest server, which
+// validates http-01 and dns-01 challenges with the key authorizations
+// presented to the returned providers
+func newTestClient(t *testing.T) (*Client, *acmetest.Server, *recordingProvider, *recordingProvider) {
+	srv, err := acmetest.NewServer()
+
+	if err != nil {
+		t.Fatalf("error starting acme server: %s", err.Error())
+	}
+
+	http01, dns01 := newRecordingProvider(), newRecordingProvider()
+
+	srv.HTTP01 = func(domain, token string) (string, error) {
+		return http01.get(domain), nil
+	}
+
+	srv.DNS01 = func(name string) ([]string, error) {
+		domain := strings.TrimPrefix(name, "_acme-challenge.")
+		var res []string
+
+		for _, d := range []string{domain, "*." + domain} {
+			if keyAuth := dns01.get(d); len(keyAuth) > 0 {
+				digest := sha256.Sum256([]byte(keyAuth))
+				res = append(res, base64.RawURLEncoding.EncodeToString(digest[:]))
+			}
+		}
+
+		return res, nil
+	}
+
+	user, err := Register(srv.URL, "test@example.com")
+
+	if err != nil {
+		srv.Close()
+		t.Fatalf("error registering account: %s", err.Error())
+	}
+
+	c, err := NewClient(srv.URL, user)
+
+	if err != nil {
+		srv.Close()
+		t.Fatalf("error creating client: %s", err.Error())
+	}
+
+	if _, err := c.Register(); err != nil {
+		srv.Close()
+		t.Fatalf("error registering existing account: %s", err.Error())
+	}
+
+	return c, srv, http01, dns01
+}
+
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	return key
+}
+
+func parseCertificate(t *testing.T, certPEM []byte) *x509.Certificate {
+	block, _ := pem.Decode(certPEM)
+
+	if block == nil {
+		t.Fatalf("no PEM data found in certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		t.Fatalf("error parsing certificate: %s", err.Error())
+	}
+
+	return cert
+}
+
+// sameNames returns true if a and b hold the same names in any order
+func sameNames(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return reflect.DeepEqual(a, b)
+}
+
+func TestObtainCertificateHTTP01(t *testing.T) {
+	c, srv, http01, _ := newTestClient(t)
+	defer srv.Close()
+
+	domains := []string{"a.example.com", "b.example.com"}
+	res, err := c.ObtainCertificate(domains, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: http01})
+
+	if err != nil {
+		t.Fatalf("error obtaining certificate: %s", err.Error())
+	}
+
+	cert := parseCertificate(t, res.Certificate)
+
+	if !sameNames(cert.DNSNames, domains) {
+		t.Errorf("expected certificate for %s, got %s", domains, cert.DNSNames)
+	}
+
+	if err := cert.CheckSignatureFrom(srv.CACertificate()); err != nil {
+		t.Errorf("expected certificate to be signed by the acme server: %s", err.Error())
+	}
+
+	for _, d := range domains {
+		if len(http01.get(d)) == 0 {
+			t.Errorf("expected an http-01 challenge to be presented for %s", d)
+		}
+	}
+}
+
+func TestObtainCertificateWildcardDNS01(t *testing.T) {
+	c, srv, _, dns01 := newTestClient(t)
+	defer srv.Close()
+
+	domains := []string{"*.example.com"}
+	res, err := c.ObtainCertificate(domains, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.DNS01: dns01})
+
+	if err != nil {
+		t.Fatalf("error obtaining certificate: %s", err.Error())
+	}
+
+	if cert := parseCertificate(t, res.Certificate); !sameNames(cert.DNSNames, domains) {
+		t.Errorf("expected certificate for %s, got %s", domains, cert.DNSNames)
+	}
+
+	if len(dns01.get("*.example.com")) == 0 {
+		t.Errorf("expected the dns-01 challenge to be presented for the wildcard domain")
+	}
+}
+
+func TestObtainCertificateBadNonceRetry(t *testing.T) {
+	c, srv, http01, _ := newTestClient(t)
+	defer srv.Close()
+
+	srv.InjectError(acmetest.EndpointNewOrder, 400, "badNonce", "injected bad nonce")
+
+	if _, err := c.ObtainCertificate([]string{"a.example.com"}, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: http01}); err != nil {
+		t.Fatalf("expected the request to be retried after a badNonce error, got: %s", err.Error())
+	}
+}
+
+func TestObtainCertificateErrorClass(t *testing.T) {
+	c, srv, http01, _ := newTestClient(t)
+	defer srv.Close()
+
+	srv.InjectError(acmetest.EndpointNewOrder, 429, "rateLimited", "too many certificates")
+
+	_, err := c.ObtainCertificate([]string{"a.example.com"}, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: http01})
+
+	if err == nil {
+		t.Fatalf("expected a rate limited error")
+	}
+
+	if class := ClassifyError(err); class != ErrorRateLimited {
+		t.Errorf("expected error class %s, got %s: %s", ErrorRateLimited, class, err.Error())
+	}
+
+	// nothing is presented by this provider, so validation fails
+	_, err = c.ObtainCertificate([]string{"b.example.com"}, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: newRecordingProvider()})
+
+	if err == nil {
+		t.Fatalf("expected a validation error")
+	}
+
+	if class := ClassifyError(err); class != ErrorValidation {
+		t.Errorf("expected error class %s, got %s: %s", ErrorValidation, class, err.Error())
+	}
+
+	if authErr, ok := Cause(err).(*AuthorizationError); !ok || !sameNames(authErr.FailedValidations(), []string{"b.example.com"}) {
+		t.Errorf("expected failed validation of b.example.com, got: %s", err.Error())
+	}
+}
+
+func TestRevokeCertificate(t *testing.T) {
+	c, srv, http01, _ := newTestClient(t)
+	defer srv.Close()
+
+	res, err := c.ObtainCertificate([]string{"a.example.com"}, testKey(t), CSROptions{}, map[acme.Challenge]acme.ChallengeProvider{acme.HTTP01: http01})
+
+	if err != nil {
+		t.Fatalf("error obtaining certificate: %s", err.Error())
+	}
+
+	serial := parseCertificate(t, res.Certificate).SerialNumber.String()
+
+	if err := c.RevokeCertificate(res.Certificate); err != nil {
+		t.Fatalf("error revoking certificate: %s", err.Error())
+	}
+
+	if !srv.Revoked(serial) {
+		t.Errorf("expected certificate %s to be revoked", serial)
+	}
+
+	if err := c.RevokeCertificate(res.Certificate); err == nil {
+		t.Errorf("expected an error revoking an already revoked certificate")
+	}
+}
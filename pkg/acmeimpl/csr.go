@@ -0,0 +1,86 @@
+package acmeimpl
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// idPeTLSFeature is the OID of the RFC 7633 TLS feature extension
+var idPeTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest is the status_request TLS extension, which a TLS
+// feature extension lists to require OCSP stapling
+const tlsFeatureStatusRequest = 5
+
+// CSROptions are optional features of the certificate signing request
+type CSROptions struct {
+	// MustStaple requests a certificate with the TLS feature extension for
+	// status_request, so that clients require an OCSP staple
+	MustStaple bool
+
+	// Extensions are added to the certificate signing request as is
+	Extensions []pkix.Extension
+}
+
+func (o CSROptions) extensions() ([]pkix.Extension, error) {
+	exts := append([]pkix.Extension{}, o.Extensions...)
+
+	if o.MustStaple {
+		value, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+
+		if err != nil {
+			return nil, err
+		}
+
+		exts = append(exts, pkix.Extension{Id: idPeTLSFeature, Value: value})
+	}
+
+	return exts, nil
+}
+
+// HasMustStaple returns true if cert has a TLS feature extension that lists
+// status_request
+func HasMustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeTLSFeature) {
+			continue
+		}
+
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+
+		for _, f := range features {
+			if f == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func generateCSR(privKey crypto.PrivateKey, domains []string, opts CSROptions) ([]byte, error) {
+	exts, err := opts.extensions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.CertificateRequest{
+		DNSNames:        domains,
+		ExtraExtensions: exts,
+	}
+
+	// the common name is limited to 64 characters, longer names are only
+	// included as SANs
+	if len(domains[0]) <= 64 {
+		template.Subject = pkix.Name{CommonName: domains[0]}
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, &template, privKey)
+}
@@ -0,0 +1,53 @@
+package acmeimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestGenerateCSRMustStaple(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	tests := []struct {
+		mustStaple bool
+	}{
+		{mustStaple: false},
+		{mustStaple: true},
+	}
+
+	for _, test := range tests {
+		der, err := generateCSR(key, []string{"example.com"}, CSROptions{MustStaple: test.mustStaple})
+
+		if err != nil {
+			t.Fatalf("error generating csr: %s", err.Error())
+		}
+
+		csr, err := x509.ParseCertificateRequest(der)
+
+		if err != nil {
+			t.Fatalf("error parsing csr: %s", err.Error())
+		}
+
+		found := false
+		for _, ext := range csr.Extensions {
+			if ext.Id.String() == "1.3.6.1.5.5.7.1.24" {
+				found = true
+			}
+		}
+
+		if found != test.mustStaple {
+			t.Errorf("must staple %t: expected tls feature extension %t, got %t", test.mustStaple, test.mustStaple, found)
+		}
+
+		if has := HasMustStaple(&x509.Certificate{Extensions: csr.Extensions}); has != test.mustStaple {
+			t.Errorf("must staple %t: expected HasMustStaple %t, got %t", test.mustStaple, test.mustStaple, has)
+		}
+	}
+}
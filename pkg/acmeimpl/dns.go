@@ -0,0 +1,53 @@
+package acmeimpl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// DNSProviders are the named dns-01 challenge providers that a
+// CertificateRequest can select between
+type DNSProviders map[string]acme.ChallengeProvider
+
+// Get returns the provider with the given name, or the only configured
+// provider if name is empty and there is exactly one
+func (d DNSProviders) Get(name string) (acme.ChallengeProvider, error) {
+	if len(name) == 0 {
+		if len(d) == 1 {
+			for _, p := range d {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("a dns provider must be chosen from the %d configured", len(d))
+	}
+
+	if p, ok := d[name]; ok {
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("unknown dns provider '%s'", name)
+}
+
+// IsWildcard returns true if domain is a wildcard domain such as
+// *.example.com
+func IsWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
+
+// ChallengeSecretName returns the name of the secret used to lock domain and
+// store its challenges. Wildcard domains are named '<base>-acme-wildcard',
+// which can not collide with the '<host>-acme' name of any other host.
+func ChallengeSecretName(domain string) string {
+	if IsWildcard(domain) {
+		return fmt.Sprintf("%s-acme-wildcard", strings.TrimPrefix(domain, "*."))
+	}
+	return fmt.Sprintf("%s-acme", domain)
+}
+
+// dns01Record returns the record that fulfils a dns-01 challenge for domain.
+// Wildcard domains are validated with a record on the base domain.
+func dns01Record(domain, keyAuth string) (fqdn, value string, ttl int) {
+	return acme.DNS01Record(strings.TrimPrefix(domain, "*."), keyAuth)
+}
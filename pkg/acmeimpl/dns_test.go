@@ -0,0 +1,28 @@
+package acmeimpl
+
+import "testing"
+
+func TestChallengeSecretName(t *testing.T) {
+	tests := map[string]string{
+		"example.com":          "example.com-acme",
+		"wildcard.example.com": "wildcard.example.com-acme",
+		"*.example.com":        "example.com-acme-wildcard",
+		"*.wildcard.com":       "wildcard.com-acme-wildcard",
+	}
+
+	seen := map[string]string{}
+
+	for domain, expected := range tests {
+		name := ChallengeSecretName(domain)
+
+		if name != expected {
+			t.Errorf("expected secret name for '%s' to be '%s', got '%s'", domain, expected, name)
+		}
+
+		if other, ok := seen[name]; ok {
+			t.Errorf("'%s' and '%s' have the same secret name '%s'", domain, other, name)
+		}
+
+		seen[name] = domain
+	}
+}
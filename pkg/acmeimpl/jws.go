@@ -0,0 +1,212 @@
+package acmeimpl
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	// register the hash functions used by jwsAlgorithm
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/square/go-jose"
+)
+
+// jws signs request bodies as flattened JSON web signatures. Requests are
+// signed with the account url as the 'kid' once it is known, and with the
+// full 'jwk' before then (as required when creating an account).
+type jws struct {
+	privKey crypto.PrivateKey
+	kid     string
+	nonces  *noncePool
+}
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func (j *jws) sign(url string, payload []byte) ([]byte, error) {
+	alg, hash, err := jwsAlgorithm(j.privKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := j.nonces.Nonce()
+
+	if err != nil {
+		return nil, wrapError(err, "error getting nonce")
+	}
+
+	header := map[string]interface{}{
+		"alg":   alg,
+		"nonce": nonce,
+		"url":   url,
+	}
+
+	if len(j.kid) > 0 {
+		header["kid"] = j.kid
+	} else {
+		header["jwk"] = jose.JsonWebKey{Key: publicKey(j.privKey)}
+	}
+
+	headerBytes, err := json.Marshal(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	msg := jwsMessage{
+		Protected: base64.RawURLEncoding.EncodeToString(headerBytes),
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+	}
+
+	h := hash.New()
+	h.Write([]byte(msg.Protected + "." + msg.Payload))
+
+	sig, err := signDigest(j.privKey, hash, h.Sum(nil))
+
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Signature = base64.RawURLEncoding.EncodeToString(sig)
+
+	return json.Marshal(msg)
+}
+
+// keyAuthorization returns the key authorization for token, as used to
+// respond to all challenge types
+func (j *jws) keyAuthorization(token string) (string, error) {
+	jwk := jose.JsonWebKey{Key: publicKey(j.privKey)}
+
+	thumb, err := jwk.Thumbprint(crypto.SHA256)
+
+	if err != nil {
+		return "", err
+	}
+
+	return token + "." + base64.RawURLEncoding.EncodeToString(thumb), nil
+}
+
+func publicKey(key crypto.PrivateKey) crypto.PublicKey {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	}
+	return nil
+}
+
+func jwsAlgorithm(key crypto.PrivateKey) (string, crypto.Hash, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", crypto.SHA256, nil
+		case elliptic.P384():
+			return "ES384", crypto.SHA384, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unsupported account key type %T", key)
+}
+
+func signDigest(key crypto.PrivateKey, hash crypto.Hash, digest []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, size*2)
+		copyPadded(sig[:size], r)
+		copyPadded(sig[size:], s)
+
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported account key type %T", key)
+}
+
+func copyPadded(dst []byte, i *big.Int) {
+	b := i.Bytes()
+	copy(dst[len(dst)-len(b):], b)
+}
+
+// noncePool holds replay nonces returned by the acme server, fetching a new
+// one from the newNonce endpoint when the pool is empty
+type noncePool struct {
+	url        string
+	httpClient *http.Client
+
+	lock   sync.Mutex
+	nonces []string
+}
+
+func (n *noncePool) Nonce() (string, error) {
+	n.lock.Lock()
+
+	if l := len(n.nonces); l > 0 {
+		nonce := n.nonces[l-1]
+		n.nonces = n.nonces[:l-1]
+		n.lock.Unlock()
+		return nonce, nil
+	}
+
+	n.lock.Unlock()
+
+	req, err := http.NewRequest("HEAD", n.url, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := n.httpClient.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+
+	if len(nonce) == 0 {
+		return "", fmt.Errorf("server did not return a Replay-Nonce from %s", n.url)
+	}
+
+	return nonce, nil
+}
+
+// Add stores the nonce from resp in the pool, if there is one
+func (n *noncePool) Add(resp *http.Response) {
+	nonce := resp.Header.Get("Replay-Nonce")
+
+	if len(nonce) == 0 {
+		return
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.nonces = append(n.nonces, nonce)
+}
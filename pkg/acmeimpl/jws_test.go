@@ -0,0 +1,37 @@
+package acmeimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignNonceErrorIsNetworkError(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	// the nonce endpoint is unreachable once the server is closed
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	j := &jws{
+		privKey: key,
+		nonces:  &noncePool{url: srv.URL, httpClient: http.DefaultClient},
+	}
+
+	_, err = j.sign(srv.URL, []byte("{}"))
+
+	if err == nil {
+		t.Fatalf("expected an error signing without a nonce")
+	}
+
+	if c := ClassifyError(err); c != ErrorNetwork {
+		t.Errorf("expected the error to be classified as %v, got %v: %s", ErrorNetwork, c, err.Error())
+	}
+}
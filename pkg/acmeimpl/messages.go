@@ -0,0 +1,61 @@
+package acmeimpl
+
+import (
+	"github.com/xenolf/lego/acme"
+)
+
+// directory is an RFC 8555 acme directory object
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+	Meta       struct {
+		TermsOfService string `json:"termsOfService"`
+	} `json:"meta"`
+}
+
+type account struct {
+	Status               string   `json:"status,omitempty"`
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting,omitempty"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status,omitempty"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations,omitempty"`
+	Finalize       string       `json:"finalize,omitempty"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+type authorization struct {
+	Identifier identifier  `json:"identifier"`
+	Status     string      `json:"status"`
+	Challenges []challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
+}
+
+type challenge struct {
+	Type   acme.Challenge `json:"type"`
+	URL    string         `json:"url"`
+	Status string         `json:"status"`
+	Token  string         `json:"token"`
+	Error  *Problem       `json:"error,omitempty"`
+}
+
+type finalizeRequest struct {
+	CSR string `json:"csr"`
+}
+
+type revokeRequest struct {
+	Certificate string `json:"certificate"`
+}
@@ -0,0 +1,156 @@
+package acmeimpl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	problemPrefix = "urn:ietf:params:acme:error:"
+
+	ProblemBadNonce       = problemPrefix + "badNonce"
+	ProblemRateLimited    = problemPrefix + "rateLimited"
+	ProblemServerInternal = problemPrefix + "serverInternal"
+	ProblemUnauthorized   = problemPrefix + "unauthorized"
+)
+
+// Problem is an RFC 7807 problem document returned by an acme server
+type Problem struct {
+	Type        string    `json:"type"`
+	Detail      string    `json:"detail"`
+	Status      int       `json:"status,omitempty"`
+	Subproblems []Problem `json:"subproblems,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	msg := p.Detail
+
+	if len(p.Type) > 0 {
+		msg = fmt.Sprintf("%s: %s", strings.TrimPrefix(p.Type, problemPrefix), p.Detail)
+	}
+
+	for _, sp := range p.Subproblems {
+		msg += fmt.Sprintf("; %s", sp.Error())
+	}
+
+	return msg
+}
+
+// AuthorizationError is returned when the authorizations for one or more
+// domains of an order could not be completed
+type AuthorizationError struct {
+	// Errors holds the error for each domain
+	Errors map[string]error
+}
+
+func (e *AuthorizationError) Error() string {
+	return mapErrsToErr(e.Errors).Error()
+}
+
+// FailedValidations returns the domains the acme server failed to validate
+func (e *AuthorizationError) FailedValidations() []string {
+	var res []string
+
+	for d, err := range e.Errors {
+		if _, ok := err.(*validationError); ok {
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
+
+// validationError is the problem reported by the acme server when it failed
+// to validate a challenge
+type validationError struct {
+	*Problem
+}
+
+// wrappedError adds context to an error while keeping it available to Cause
+type wrappedError struct {
+	msg string
+	err error
+}
+
+func (w *wrappedError) Error() string {
+	return fmt.Sprintf("%s: %s", w.msg, w.err.Error())
+}
+
+func wrapError(err error, format string, args ...interface{}) error {
+	return &wrappedError{msg: fmt.Sprintf(format, args...), err: err}
+}
+
+// Cause returns the underlying error of an error returned by this package
+func Cause(err error) error {
+	for {
+		w, ok := err.(*wrappedError)
+
+		if !ok {
+			return err
+		}
+
+		err = w.err
+	}
+}
+
+// ErrorClass is a broad category of errors returned by an acme server
+type ErrorClass string
+
+const (
+	// ErrorNetwork is a failure to reach the acme server
+	ErrorNetwork ErrorClass = "network"
+	// ErrorRateLimited is a rate limit imposed by the acme server
+	ErrorRateLimited ErrorClass = "rateLimited"
+	// ErrorServerInternal is an internal error of the acme server
+	ErrorServerInternal ErrorClass = "serverInternal"
+	// ErrorValidation is a challenge the acme server failed to validate
+	ErrorValidation ErrorClass = "validation"
+	// ErrorOther is any other error
+	ErrorOther ErrorClass = "other"
+)
+
+// classPrecedence orders the classes of the errors of an AuthorizationError,
+// preferring those an order could succeed with from another acme server
+var classPrecedence = map[ErrorClass]int{
+	ErrorOther:          0,
+	ErrorNetwork:        1,
+	ErrorServerInternal: 2,
+	ErrorRateLimited:    3,
+}
+
+// ClassifyError returns the class of an error returned by this package
+func ClassifyError(err error) ErrorClass {
+	switch e := Cause(err).(type) {
+	case *AuthorizationError:
+		if len(e.FailedValidations()) > 0 {
+			return ErrorValidation
+		}
+
+		// the errors are combined in a fixed order, so that the class does
+		// not depend on the order of the map
+		class := ErrorOther
+
+		for _, err := range e.Errors {
+			if c := ClassifyError(err); classPrecedence[c] > classPrecedence[class] {
+				class = c
+			}
+		}
+
+		return class
+	case *validationError:
+		return ErrorValidation
+	case *Problem:
+		if e.Type == ProblemRateLimited {
+			return ErrorRateLimited
+		}
+
+		if e.Type == ProblemServerInternal || e.Status >= 500 {
+			return ErrorServerInternal
+		}
+	case net.Error:
+		return ErrorNetwork
+	}
+
+	return ErrorOther
+}
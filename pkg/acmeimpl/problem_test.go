@@ -0,0 +1,36 @@
+package acmeimpl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyAuthorizationError(t *testing.T) {
+	rateLimited := &Problem{Type: ProblemRateLimited, Status: 429}
+	internal := &Problem{Type: ProblemServerInternal, Status: 500}
+	invalid := &validationError{&Problem{Type: ProblemUnauthorized, Status: 403}}
+
+	tests := []struct {
+		name     string
+		errs     map[string]error
+		expected ErrorClass
+	}{
+		{"single", map[string]error{"a.example.com": internal}, ErrorServerInternal},
+		{"rate limited and other", map[string]error{"a.example.com": errors.New("other"), "b.example.com": rateLimited, "c.example.com": errors.New("other")}, ErrorRateLimited},
+		{"rate limited and internal", map[string]error{"a.example.com": internal, "b.example.com": rateLimited}, ErrorRateLimited},
+		{"validation", map[string]error{"a.example.com": rateLimited, "b.example.com": invalid}, ErrorValidation},
+		{"other", map[string]error{"a.example.com": errors.New("other"), "b.example.com": errors.New("other")}, ErrorOther},
+	}
+
+	for _, test := range tests {
+		// map iteration order is random, so classify a few times
+		for i := 0; i < 10; i++ {
+			err := wrapError(&AuthorizationError{Errors: test.errs}, "error solving authorizations")
+
+			if class := ClassifyError(err); class != test.expected {
+				t.Errorf("%s: expected %s, got %s", test.name, test.expected, class)
+				break
+			}
+		}
+	}
+}
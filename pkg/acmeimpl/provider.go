@@ -2,44 +2,128 @@ package acmeimpl
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 )
 
+const (
+	// challengeKeyPrefix prefixes the challenge type and token of each key
+	// authorization stored in a challenge secret
+	challengeKeyPrefix = "acme-auth."
+
+	// maxConflictRetries is the number of times a challenge secret update is
+	// retried when the secret is modified concurrently
+	maxConflictRetries = 5
+)
+
+// SecretsProvider presents challenges of one type by storing the key
+// authorization for each token in the lock secret for the domain, from where
+// they are served over http, dns and tls by the serve command. Challenges for
+// the same domain with different tokens can be presented at the same time.
 type SecretsProvider struct {
-	kubeClient *client.Client
-	namespace  string
+	secrets       client.SecretsNamespacer
+	namespace     string
+	challengeType acme.Challenge
 }
 
-func (sp *SecretsProvider) Present(domain, token, keyAuth string) error {
-	secret, err := sp.kubeClient.Secrets(sp.namespace).Get(fmt.Sprintf("%s-acme", domain))
+// PresentedChallenge is a challenge stored in a challenge secret
+type PresentedChallenge struct {
+	Type    acme.Challenge
+	Token   string
+	KeyAuth string
+}
 
-	if err != nil {
-		return err
-	}
+// ChallengeKey returns the key the key authorization for a challenge of type
+// challengeType with token is stored under in a challenge secret
+func ChallengeKey(challengeType acme.Challenge, token string) string {
+	return fmt.Sprintf("%s%s.%s", challengeKeyPrefix, challengeType, token)
+}
 
-	if secret.Data == nil {
-		secret.Data = make(map[string][]byte)
-	}
-	secret.Data["acme-token"] = []byte(token)
-	secret.Data["acme-auth"] = []byte(keyAuth)
+// Challenges returns the challenges presented in secret
+func Challenges(secret *api.Secret) []PresentedChallenge {
+	var res []PresentedChallenge
+
+	for k, v := range secret.Data {
+		if !strings.HasPrefix(k, challengeKeyPrefix) {
+			continue
+		}
 
-	secret, err = sp.kubeClient.Secrets(sp.namespace).Update(secret)
+		// challenge types and tokens never contain a '.'
+		parts := strings.SplitN(strings.TrimPrefix(k, challengeKeyPrefix), ".", 2)
 
-	if err != nil {
-		return err
+		if len(parts) != 2 {
+			continue
+		}
+
+		res = append(res, PresentedChallenge{
+			Type:    acme.Challenge(parts[0]),
+			Token:   parts[1],
+			KeyAuth: string(v),
+		})
 	}
 
-	return nil
+	return res
+}
+
+func (sp *SecretsProvider) Present(domain, token, keyAuth string) error {
+	return sp.update(domain, func(secret *api.Secret) {
+		// challenges stored before they were keyed by token
+		delete(secret.Data, "acme-token")
+		delete(secret.Data, "acme-auth")
+
+		secret.Data[ChallengeKey(sp.challengeType, token)] = []byte(keyAuth)
+	})
 }
 
 func (sp *SecretsProvider) CleanUp(domain, token, keyAuth string) error {
-	return nil
+	err := sp.update(domain, func(secret *api.Secret) {
+		delete(secret.Data, ChallengeKey(sp.challengeType, token))
+	})
+
+	// the lock secret may already have been released
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// update applies fn to the challenge secret for domain and stores the
+// result, retrying if the secret is modified concurrently
+func (sp *SecretsProvider) update(domain string, fn func(*api.Secret)) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		secret, gerr := sp.secrets.Secrets(sp.namespace).Get(ChallengeSecretName(domain))
+
+		if gerr != nil {
+			return gerr
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+
+		fn(secret)
+
+		if _, err = sp.secrets.Secrets(sp.namespace).Update(secret); err == nil || !kerrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("error updating challenge secret: %s", err.Error())
 }
 
-func NewSecretsProvider(kubeClient *client.Client, ns string) (*SecretsProvider, error) {
+// NewSecretsProvider returns a provider that presents challenges of type
+// challengeType in the lock secrets in namespace ns
+func NewSecretsProvider(secrets client.SecretsNamespacer, ns string, challengeType acme.Challenge) (*SecretsProvider, error) {
 	return &SecretsProvider{
-		kubeClient: kubeClient,
-		namespace:  ns,
+		secrets:       secrets,
+		namespace:     ns,
+		challengeType: challengeType,
 	}, nil
 }
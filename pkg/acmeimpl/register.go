@@ -0,0 +1,37 @@
+package acmeimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+)
+
+// Register generates a new account key for email, registers it with the
+// acme server at server and agrees to the servers terms of service. The
+// returned User contains everything needed to use the account again later.
+func Register(server, email string) (User, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return User{}, fmt.Errorf("error generating account key: %s", err.Error())
+	}
+
+	user := NewUser(email, privKey, nil)
+
+	client, err := NewClient(server, user)
+
+	if err != nil {
+		return User{}, err
+	}
+
+	reg, err := client.Register()
+
+	if err != nil {
+		return User{}, fmt.Errorf("error registering account: %s", err.Error())
+	}
+
+	user.Registration = reg
+
+	return user, nil
+}
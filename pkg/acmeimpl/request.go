@@ -2,6 +2,7 @@ package acmeimpl
 
 import (
 	"crypto"
+	"time"
 
 	"github.com/xenolf/lego/acme"
 )
@@ -11,4 +12,21 @@ type CertificateRequest struct {
 	ExistingResource acme.CertificateResource
 	Hosts            []string
 	PrivateKey       crypto.PrivateKey
+	// KeyCreated is when PrivateKey was generated, if known
+	KeyCreated time.Time
+
+	// KeyType is the type of key to generate if PrivateKey is not set,
+	// defaulting to the key type of the AcmeImpl
+	KeyType acme.KeyType
+
+	// ChallengeType is the type of challenge to solve, defaulting to http-01
+	ChallengeType acme.Challenge
+	// DNSProvider is the name of the provider used for dns-01 challenges
+	DNSProvider string
+	// SelfCheck checks that http-01 challenges are served correctly before
+	// the acme server is asked to validate them
+	SelfCheck bool
+
+	// CSR holds optional features of the certificate signing request
+	CSR CSROptions
 }
@@ -0,0 +1,181 @@
+package acmeimpl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RFC2136Provider presents dns-01 challenges by sending RFC 2136 dynamic
+// updates, optionally signed with a TSIG key, to a nameserver
+type RFC2136Provider struct {
+	nameserver    string
+	zone          string
+	tsigAlgorithm string
+	tsigKey       string
+	tsigSecret    string
+
+	// PropagationWait is how long to wait after the nameserver has accepted
+	// an update, to allow it to reach any secondary nameservers
+	PropagationWait time.Duration
+}
+
+func (r *RFC2136Provider) Present(domain, token, keyAuth string) error {
+	fqdn, value, ttl := dns01Record(domain, keyAuth)
+
+	if err := r.update(fqdn, value, ttl, true); err != nil {
+		return err
+	}
+
+	if err := r.verify(fqdn, value); err != nil {
+		return err
+	}
+
+	time.Sleep(r.PropagationWait)
+
+	return nil
+}
+
+func (r *RFC2136Provider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, ttl := dns01Record(domain, keyAuth)
+
+	return r.update(fqdn, value, ttl, false)
+}
+
+// update inserts or removes a single TXT record for fqdn
+func (r *RFC2136Provider) update(fqdn, value string, ttl int, insert bool) error {
+	zone, err := r.findZone(fqdn)
+
+	if err != nil {
+		return err
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(ttl)},
+		Txt: []string{value},
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+
+	if insert {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	reply, err := r.exchange(m)
+
+	if err != nil {
+		return fmt.Errorf("error sending dns update for '%s': %s", fqdn, err.Error())
+	}
+
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dns update for '%s' failed: %s", fqdn, dns.RcodeToString[reply.Rcode])
+	}
+
+	return nil
+}
+
+// verify checks the nameserver now answers with value for fqdn
+func (r *RFC2136Provider) verify(fqdn, value string) error {
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, dns.TypeTXT)
+
+	reply, err := r.exchange(m)
+
+	if err != nil {
+		return fmt.Errorf("error verifying dns update for '%s': %s", fqdn, err.Error())
+	}
+
+	for _, rr := range reply.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			for _, t := range txt.Txt {
+				if t == value {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("nameserver %s accepted the update for '%s' but does not serve it", r.nameserver, fqdn)
+}
+
+// findZone returns the configured zone, or otherwise asks the nameserver for
+// the SOA of each parent of fqdn in turn until one is found
+func (r *RFC2136Provider) findZone(fqdn string) (string, error) {
+	if len(r.zone) > 0 {
+		return r.zone, nil
+	}
+
+	labels := dns.SplitDomainName(fqdn)
+
+	for i := range labels {
+		zone := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		m := new(dns.Msg)
+		m.SetQuestion(zone, dns.TypeSOA)
+
+		reply, err := r.exchange(m)
+
+		if err != nil {
+			return "", fmt.Errorf("error finding zone for '%s': %s", fqdn, err.Error())
+		}
+
+		for _, rr := range reply.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Name == zone {
+				return zone, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("nameserver %s is not authoritative for '%s'", r.nameserver, fqdn)
+}
+
+func (r *RFC2136Provider) exchange(m *dns.Msg) (*dns.Msg, error) {
+	c := new(dns.Client)
+
+	if len(r.tsigKey) > 0 {
+		c.TsigSecret = map[string]string{r.tsigKey: r.tsigSecret}
+		m.SetTsig(r.tsigKey, r.tsigAlgorithm, 300, time.Now().Unix())
+	}
+
+	reply, _, err := c.Exchange(m, r.nameserver)
+
+	return reply, err
+}
+
+// NewRFC2136Provider returns a provider that sends updates to nameserver
+// (host:port). If zone is empty it is discovered from the nameserver. If
+// tsigKey is empty, updates are sent unsigned.
+func NewRFC2136Provider(nameserver, zone, tsigAlgorithm, tsigKey, tsigSecret string) (*RFC2136Provider, error) {
+	if len(nameserver) == 0 {
+		return nil, fmt.Errorf("rfc2136 nameserver must be set")
+	}
+
+	if len(tsigKey) > 0 && len(tsigSecret) == 0 {
+		return nil, fmt.Errorf("rfc2136 tsig secret must be set when using a tsig key")
+	}
+
+	if len(tsigAlgorithm) == 0 {
+		tsigAlgorithm = dns.HmacSHA256
+	}
+
+	if len(zone) > 0 {
+		zone = dns.Fqdn(zone)
+	}
+
+	if len(tsigKey) > 0 {
+		tsigKey = dns.Fqdn(tsigKey)
+	}
+
+	return &RFC2136Provider{
+		nameserver:    nameserver,
+		zone:          zone,
+		tsigAlgorithm: dns.Fqdn(tsigAlgorithm),
+		tsigKey:       tsigKey,
+		tsigSecret:    tsigSecret,
+	}, nil
+}
@@ -0,0 +1,222 @@
+package acmeimpl
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGKey    = "kube-acme."
+	testTSIGSecret = "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="
+)
+
+// fakeNameserver is an in-process nameserver authoritative for zone, that
+// applies TSIG signed updates to TXT records
+type fakeNameserver struct {
+	zone string
+
+	lock    sync.Mutex
+	records map[string][]string
+	updates int
+}
+
+func (s *fakeNameserver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	q := r.Question[0]
+
+	switch {
+	case r.Opcode == dns.OpcodeUpdate && (r.IsTsig() == nil || w.TsigStatus() != nil):
+		m.Rcode = dns.RcodeRefused
+	case r.Opcode == dns.OpcodeUpdate && q.Name != s.zone:
+		m.Rcode = dns.RcodeNotZone
+	case r.Opcode == dns.OpcodeUpdate:
+		s.updates++
+
+		for _, rr := range r.Ns {
+			txt, ok := rr.(*dns.TXT)
+
+			if !ok {
+				continue
+			}
+
+			name := strings.ToLower(txt.Hdr.Name)
+
+			if txt.Hdr.Class == dns.ClassNONE {
+				s.records[name] = removeAll(s.records[name], txt.Txt)
+			} else {
+				s.records[name] = append(s.records[name], txt.Txt...)
+			}
+		}
+	case q.Qtype == dns.TypeSOA && q.Name == s.zone:
+		m.Answer = append(m.Answer, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+			Ns:     "ns." + s.zone,
+			Mbox:   "hostmaster." + s.zone,
+			Serial: 1,
+		})
+	case q.Qtype == dns.TypeTXT:
+		for _, v := range s.records[strings.ToLower(q.Name)] {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{v},
+			})
+		}
+	}
+
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+
+	w.WriteMsg(m)
+}
+
+func (s *fakeNameserver) txt(name string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.records[name]
+}
+
+func removeAll(values, remove []string) []string {
+	var res []string
+
+	for _, v := range values {
+		keep := true
+		for _, r := range remove {
+			if v == r {
+				keep = false
+			}
+		}
+		if keep {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// startNameserver starts a fakeNameserver for zone on a local udp port,
+// returning it, its address and a func to stop it
+func startNameserver(t *testing.T, zone string) (*fakeNameserver, string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("error listening: %s", err.Error())
+	}
+
+	ns := &fakeNameserver{zone: zone, records: map[string][]string{}}
+
+	var started sync.WaitGroup
+	started.Add(1)
+
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           ns,
+		TsigSecret:        map[string]string{testTSIGKey: testTSIGSecret},
+		NotifyStartedFunc: started.Done,
+	}
+
+	go srv.ActivateAndServe()
+	started.Wait()
+
+	return ns, pc.LocalAddr().String(), func() { srv.Shutdown() }
+}
+
+func TestRFC2136PresentAndCleanUp(t *testing.T) {
+	ns, addr, stop := startNameserver(t, "example.com.")
+	defer stop()
+
+	p, err := NewRFC2136Provider(addr, "", dns.HmacSHA256, testTSIGKey, testTSIGSecret)
+
+	if err != nil {
+		t.Fatalf("error creating provider: %s", err.Error())
+	}
+
+	fqdn, value, _ := dns01Record("*.foo.example.com", "token.thumbprint")
+
+	if err := p.Present("*.foo.example.com", "token", "token.thumbprint"); err != nil {
+		t.Fatalf("error presenting challenge: %s", err.Error())
+	}
+
+	if got := ns.txt(fqdn); len(got) != 1 || got[0] != value {
+		t.Errorf("expected %s to have TXT record %q, got %q", fqdn, value, got)
+	}
+
+	if err := p.CleanUp("*.foo.example.com", "token", "token.thumbprint"); err != nil {
+		t.Fatalf("error cleaning up challenge: %s", err.Error())
+	}
+
+	if got := ns.txt(fqdn); len(got) != 0 {
+		t.Errorf("expected TXT records for %s to be removed, got %q", fqdn, got)
+	}
+
+	if ns.updates != 2 {
+		t.Errorf("expected 2 updates, got %d", ns.updates)
+	}
+}
+
+func TestRFC2136FindZone(t *testing.T) {
+	_, addr, stop := startNameserver(t, "example.com.")
+	defer stop()
+
+	p, err := NewRFC2136Provider(addr, "", dns.HmacSHA256, testTSIGKey, testTSIGSecret)
+
+	if err != nil {
+		t.Fatalf("error creating provider: %s", err.Error())
+	}
+
+	zone, err := p.findZone("_acme-challenge.a.b.example.com.")
+
+	if err != nil {
+		t.Fatalf("error finding zone: %s", err.Error())
+	}
+
+	if zone != "example.com." {
+		t.Errorf("expected zone example.com., got %s", zone)
+	}
+
+	if _, err := p.findZone("_acme-challenge.example.org."); err == nil {
+		t.Errorf("expected an error finding the zone of a name the nameserver is not authoritative for")
+	}
+
+	p, _ = NewRFC2136Provider(addr, "other.example.com", dns.HmacSHA256, testTSIGKey, testTSIGSecret)
+
+	if zone, _ := p.findZone("_acme-challenge.example.com."); zone != "other.example.com." {
+		t.Errorf("expected the configured zone to be used, got %s", zone)
+	}
+}
+
+func TestRFC2136RejectsBadTSIG(t *testing.T) {
+	ns, addr, stop := startNameserver(t, "example.com.")
+	defer stop()
+
+	p, err := NewRFC2136Provider(addr, "example.com", dns.HmacSHA256, testTSIGKey, "d3Jvbmcgc2VjcmV0")
+
+	if err != nil {
+		t.Fatalf("error creating provider: %s", err.Error())
+	}
+
+	if err := p.Present("foo.example.com", "token", "token.thumbprint"); err == nil {
+		t.Errorf("expected an update signed with the wrong secret to fail")
+	}
+
+	p, _ = NewRFC2136Provider(addr, "example.com", "", "", "")
+
+	if err := p.Present("foo.example.com", "token", "token.thumbprint"); err == nil {
+		t.Errorf("expected an unsigned update to fail")
+	}
+
+	if ns.updates != 0 {
+		t.Errorf("expected no updates to be applied, got %d", ns.updates)
+	}
+}
@@ -0,0 +1,123 @@
+package acmeimpl
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xenolf/lego/acme"
+)
+
+// HTTP01SelfCheck checks that http-01 challenges for a domain are served
+// back correctly before the acme server is asked to validate them, so that
+// misrouted ingresses do not cost failed validations
+type HTTP01SelfCheck struct {
+	// Attempts is the number of times to fetch the challenge before failing
+	Attempts int
+	// Interval is the time to wait between attempts
+	Interval time.Duration
+
+	httpClient *http.Client
+}
+
+// NewHTTP01SelfCheck returns a self check that fetches challenges like an
+// acme server does, following redirects and ignoring invalid certificates
+func NewHTTP01SelfCheck(attempts int, interval time.Duration) *HTTP01SelfCheck {
+	return &HTTP01SelfCheck{
+		Attempts: attempts,
+		Interval: interval,
+		httpClient: &http.Client{
+			Timeout: time.Second * 10,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// Check presents a canary challenge for domain with provider and fetches it
+// from http://<domain>/.well-known/acme-challenge/<token>, returning an error
+// describing the problem if the expected key authorization is not served
+func (s *HTTP01SelfCheck) Check(domain string, provider acme.ChallengeProvider) error {
+	token, err := canaryToken()
+
+	if err != nil {
+		return err
+	}
+
+	keyAuth := token + ".kube-acme-self-check"
+
+	if err := provider.Present(domain, token, keyAuth); err != nil {
+		return fmt.Errorf("self check: error presenting canary challenge: %s", err.Error())
+	}
+
+	defer func() {
+		if err := provider.CleanUp(domain, token, keyAuth); err != nil {
+			glog.Errorf("[%s] self check: error cleaning up canary challenge: %s", domain, err.Error())
+		}
+	}()
+
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", domain, token)
+
+	for i := 0; ; i++ {
+		err = s.fetch(url, keyAuth)
+
+		if err == nil {
+			glog.Infof("[%s] self check: challenge served correctly", domain)
+			return nil
+		}
+
+		if i+1 >= s.Attempts {
+			return fmt.Errorf("self check failed for %s: %s", url, err.Error())
+		}
+
+		time.Sleep(s.Interval)
+	}
+}
+
+func (s *HTTP01SelfCheck) fetch(url, keyAuth string) error {
+	resp, err := s.httpClient.Get(url)
+
+	if err != nil {
+		return fmt.Errorf("%s. check that dns for the host points at the ingress controller and that it is reachable on port 80", err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if err != nil {
+		return fmt.Errorf("error reading response: %s", err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %d. check that the ingress routes /.well-known/acme-challenge/ to the kube-acme serve service", resp.StatusCode)
+	}
+
+	if got := strings.TrimSpace(string(body)); got != keyAuth {
+		if len(got) > 64 {
+			got = got[:64] + "..."
+		}
+		return fmt.Errorf("got %q instead of the canary key authorization. the path is served by something other than kube-acme serve, or serve cannot read the challenge from the acme namespace", got)
+	}
+
+	return nil
+}
+
+func canaryToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
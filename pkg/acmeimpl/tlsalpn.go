@@ -0,0 +1,78 @@
+package acmeimpl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+const (
+	// TLSALPN01 is the RFC 8737 tls-alpn-01 challenge
+	TLSALPN01 = acme.Challenge("tls-alpn-01")
+
+	// ACMETLS1Protocol is the ALPN protocol negotiated by the acme server
+	// when validating a tls-alpn-01 challenge
+	ACMETLS1Protocol = "acme-tls/1"
+)
+
+// idPeAcmeIdentifier is the OID of the acmeIdentifier certificate extension
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPN01ChallengeCert returns a self signed certificate for domain that
+// fulfils a tls-alpn-01 challenge with the key authorization keyAuth
+func TLSALPN01ChallengeCert(domain, keyAuth string) (tls.Certificate, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	digest := sha256.Sum256([]byte(keyAuth))
+
+	extValue, err := asn1.Marshal(digest[:])
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "kube-acme tls-alpn-01 challenge"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:       idPeAcmeIdentifier,
+				Critical: true,
+				Value:    extValue,
+			},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privKey.PublicKey, privKey)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  privKey,
+	}, nil
+}
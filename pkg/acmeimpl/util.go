@@ -1,10 +1,18 @@
 package acmeimpl
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/xenolf/lego/acme"
 )
 
 func mapErrsToErr(errm map[string]error) error {
@@ -20,3 +28,96 @@ func mapErrsToErr(errm map[string]error) error {
 	}
 	return errors.New(multierror.ListFormatFunc(errs))
 }
+
+// ParsePEMPrivateKey parses a PEM encoded RSA or EC private key
+func ParsePEMPrivateKey(key []byte) (crypto.PrivateKey, error) {
+	keyBlock, _ := pem.Decode(key)
+
+	if keyBlock == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(keyBlock.Bytes)
+	default:
+		return nil, errors.New("Unknown PEM header value")
+	}
+}
+
+// EncodePEMPrivateKey PEM encodes an RSA or EC private key
+func EncodePEMPrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	var block *pem.Block
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(k)
+
+		if err != nil {
+			return nil, err
+		}
+
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+func generatePrivateKey(keyType acme.KeyType) (crypto.PrivateKey, error) {
+	switch keyType {
+	case acme.EC256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case acme.EC384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case acme.RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case acme.RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case acme.RSA8192:
+		return rsa.GenerateKey(rand.Reader, 8192)
+	}
+
+	return nil, fmt.Errorf("invalid key type: %s", keyType)
+}
+
+// ParseKeyType returns the key type named by s, one of P256, P384, 2048,
+// 4096 or 8192
+func ParseKeyType(s string) (acme.KeyType, error) {
+	switch kt := acme.KeyType(s); kt {
+	case acme.EC256, acme.EC384, acme.RSA2048, acme.RSA4096, acme.RSA8192:
+		return kt, nil
+	}
+
+	return "", fmt.Errorf("invalid key type: %s", s)
+}
+
+// KeyTypeOf returns the key type of key, or an empty key type if it is not
+// one of the supported types
+func KeyTypeOf(key crypto.PrivateKey) acme.KeyType {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return acme.EC256
+		case elliptic.P384():
+			return acme.EC384
+		}
+	case *rsa.PrivateKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return acme.RSA2048
+		case 4096:
+			return acme.RSA4096
+		case 8192:
+			return acme.RSA8192
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,304 @@
+// Package kubetest provides in-memory fakes of the kubernetes clients used by
+// kube-acme, for tests that need to read and write secrets and ingresses
+// without an apiserver.
+package kubetest
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// store holds objects by namespace and name, assigning each write a new
+// resource version. Updates of objects with a stale resource version fail
+// with a conflict, as they would against an apiserver.
+type store struct {
+	lock    sync.Mutex
+	version int
+	objects map[string]map[string]interface{}
+}
+
+func newStore() *store {
+	return &store{objects: map[string]map[string]interface{}{}}
+}
+
+func (s *store) get(ns, name string) (interface{}, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	obj, ok := s.objects[ns][name]
+
+	if !ok {
+		return nil, false
+	}
+
+	return deepCopy(obj), true
+}
+
+func (s *store) list(ns string) []interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var res []interface{}
+
+	for n, objs := range s.objects {
+		if ns != api.NamespaceAll && n != ns {
+			continue
+		}
+
+		for _, obj := range objs {
+			res = append(res, deepCopy(obj))
+		}
+	}
+
+	return res
+}
+
+// put stores obj, returning a copy of it with its new resource version. If
+// create is true it fails if obj exists, otherwise it fails if obj does not
+// exist or has been modified since it was read.
+func (s *store) put(meta *api.ObjectMeta, obj interface{}, create bool, resource string) (interface{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	existing, exists := s.objects[meta.Namespace][meta.Name]
+
+	switch {
+	case create && exists:
+		return nil, kerrors.NewAlreadyExists(api.Resource(resource), meta.Name)
+	case !create && !exists:
+		return nil, kerrors.NewNotFound(api.Resource(resource), meta.Name)
+	case !create && len(meta.ResourceVersion) > 0 && meta.ResourceVersion != objectMeta(existing).ResourceVersion:
+		return nil, kerrors.NewConflict(api.Resource(resource), meta.Name, fmt.Errorf("the object has been modified"))
+	}
+
+	obj = deepCopy(obj)
+	s.version++
+	objectMeta(obj).ResourceVersion = strconv.Itoa(s.version)
+
+	if s.objects[meta.Namespace] == nil {
+		s.objects[meta.Namespace] = map[string]interface{}{}
+	}
+
+	s.objects[meta.Namespace][meta.Name] = obj
+
+	return deepCopy(obj), nil
+}
+
+func (s *store) delete(ns, name, resource string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.objects[ns][name]; !ok {
+		return kerrors.NewNotFound(api.Resource(resource), name)
+	}
+
+	delete(s.objects[ns], name)
+
+	return nil
+}
+
+func objectMeta(obj interface{}) *api.ObjectMeta {
+	switch o := obj.(type) {
+	case *api.Secret:
+		return &o.ObjectMeta
+	case *extensions.Ingress:
+		return &o.ObjectMeta
+	}
+	panic(fmt.Sprintf("unexpected object of type %T", obj))
+}
+
+func deepCopy(obj interface{}) interface{} {
+	res, err := api.Scheme.DeepCopy(obj)
+
+	if err != nil {
+		panic(fmt.Sprintf("error copying object: %s", err.Error()))
+	}
+
+	return res
+}
+
+func matches(opts api.ListOptions, obj interface{}) bool {
+	return opts.LabelSelector == nil || opts.LabelSelector.Matches(labels.Set(objectMeta(obj).Labels))
+}
+
+// Secrets is an in-memory client.SecretsNamespacer
+type Secrets struct {
+	*store
+}
+
+var _ client.SecretsNamespacer = &Secrets{}
+
+// NewSecrets returns an empty Secrets, with secrets added to it
+func NewSecrets(secrets ...*api.Secret) *Secrets {
+	s := &Secrets{newStore()}
+
+	for _, secret := range secrets {
+		if _, err := s.Secrets(secret.Namespace).Create(secret); err != nil {
+			panic(err)
+		}
+	}
+
+	return s
+}
+
+func (s *Secrets) Secrets(namespace string) client.SecretsInterface {
+	return &secrets{s.store, namespace}
+}
+
+type secrets struct {
+	store     *store
+	namespace string
+}
+
+func (s *secrets) Create(secret *api.Secret) (*api.Secret, error) {
+	secret = deepCopy(secret).(*api.Secret)
+	secret.Namespace = s.namespace
+
+	res, err := s.store.put(&secret.ObjectMeta, secret, true, "secrets")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*api.Secret), nil
+}
+
+func (s *secrets) Update(secret *api.Secret) (*api.Secret, error) {
+	secret = deepCopy(secret).(*api.Secret)
+	secret.Namespace = s.namespace
+
+	res, err := s.store.put(&secret.ObjectMeta, secret, false, "secrets")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*api.Secret), nil
+}
+
+func (s *secrets) Delete(name string) error {
+	return s.store.delete(s.namespace, name, "secrets")
+}
+
+func (s *secrets) List(opts api.ListOptions) (*api.SecretList, error) {
+	res := &api.SecretList{}
+
+	for _, obj := range s.store.list(s.namespace) {
+		if matches(opts, obj) {
+			res.Items = append(res.Items, *obj.(*api.Secret))
+		}
+	}
+
+	return res, nil
+}
+
+func (s *secrets) Get(name string) (*api.Secret, error) {
+	obj, ok := s.store.get(s.namespace, name)
+
+	if !ok {
+		return nil, kerrors.NewNotFound(api.Resource("secrets"), name)
+	}
+
+	return obj.(*api.Secret), nil
+}
+
+func (s *secrets) Watch(opts api.ListOptions) (watch.Interface, error) {
+	return nil, fmt.Errorf("watching secrets is not supported")
+}
+
+// Ingresses is an in-memory client.IngressNamespacer
+type Ingresses struct {
+	*store
+}
+
+var _ client.IngressNamespacer = &Ingresses{}
+
+// NewIngresses returns an empty Ingresses, with ingresses added to it
+func NewIngresses(ingresses ...*extensions.Ingress) *Ingresses {
+	i := &Ingresses{newStore()}
+
+	for _, ing := range ingresses {
+		if _, err := i.Ingress(ing.Namespace).Create(ing); err != nil {
+			panic(err)
+		}
+	}
+
+	return i
+}
+
+func (i *Ingresses) Ingress(namespace string) client.IngressInterface {
+	return &ingresses{i.store, namespace}
+}
+
+type ingresses struct {
+	store     *store
+	namespace string
+}
+
+func (i *ingresses) List(opts api.ListOptions) (*extensions.IngressList, error) {
+	res := &extensions.IngressList{}
+
+	for _, obj := range i.store.list(i.namespace) {
+		if matches(opts, obj) {
+			res.Items = append(res.Items, *obj.(*extensions.Ingress))
+		}
+	}
+
+	return res, nil
+}
+
+func (i *ingresses) Get(name string) (*extensions.Ingress, error) {
+	obj, ok := i.store.get(i.namespace, name)
+
+	if !ok {
+		return nil, kerrors.NewNotFound(extensions.Resource("ingresses"), name)
+	}
+
+	return obj.(*extensions.Ingress), nil
+}
+
+func (i *ingresses) Create(ing *extensions.Ingress) (*extensions.Ingress, error) {
+	ing = deepCopy(ing).(*extensions.Ingress)
+	ing.Namespace = i.namespace
+
+	res, err := i.store.put(&ing.ObjectMeta, ing, true, "ingresses")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*extensions.Ingress), nil
+}
+
+func (i *ingresses) Update(ing *extensions.Ingress) (*extensions.Ingress, error) {
+	ing = deepCopy(ing).(*extensions.Ingress)
+	ing.Namespace = i.namespace
+
+	res, err := i.store.put(&ing.ObjectMeta, ing, false, "ingresses")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*extensions.Ingress), nil
+}
+
+func (i *ingresses) UpdateStatus(ing *extensions.Ingress) (*extensions.Ingress, error) {
+	return i.Update(ing)
+}
+
+func (i *ingresses) Delete(name string, options *api.DeleteOptions) error {
+	return i.store.delete(i.namespace, name, "ingresses")
+}
+
+func (i *ingresses) Watch(opts api.ListOptions) (watch.Interface, error) {
+	return nil, fmt.Errorf("watching ingresses is not supported")
+}
@@ -1,7 +1,9 @@
 package monitor
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"time"
 
@@ -11,6 +13,9 @@ import (
 	"k8s.io/kubernetes/pkg/api/unversioned"
 )
 
+// OCSPStapleKey is the secret data key the OCSP response is stored under
+const OCSPStapleKey = "tls.ocsp-staple"
+
 // TLSSecret Interface for mocking in tests
 type TLSSecret interface {
 	Expiry() (time.Time, error)
@@ -26,6 +31,18 @@ type DefaultTLSSecret struct {
 	Namespace string
 
 	CertificateResource acme.CertificateResource
+
+	// KeyCreated is when the private key was generated, if known
+	KeyCreated time.Time
+
+	// OCSPStaple is the DER encoded OCSP response for the certificate, if
+	// one has been fetched
+	OCSPStaple []byte
+
+	// Issuer and Directory are the name and directory url of the acme server
+	// that issued the certificate, if known
+	Issuer    string
+	Directory string
 }
 
 // Expiry returns the expiry date of the certificate, or an error
@@ -34,6 +51,17 @@ func (t *DefaultTLSSecret) Expiry() (time.Time, error) {
 	return acme.GetPEMCertExpiration(t.CertificateResource.Certificate)
 }
 
+// X509Certificate returns the parsed leaf certificate
+func (t *DefaultTLSSecret) X509Certificate() (*x509.Certificate, error) {
+	block, _ := pem.Decode(t.CertificateResource.Certificate)
+
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
 func (t *DefaultTLSSecret) Certificate() []byte {
 	if cert := t.CertificateResource.Certificate; len(cert) > 0 {
 		return cert
@@ -61,7 +89,21 @@ func (t *DefaultTLSSecret) Secret() (*api.Secret, error) {
 		return nil, err
 	}
 
-	return &api.Secret{
+	annotations := map[string]string{}
+
+	if !t.KeyCreated.IsZero() {
+		annotations["acme-key-created"] = t.KeyCreated.UTC().Format(time.RFC3339)
+	}
+
+	if len(t.Issuer) > 0 {
+		annotations["acme-issuer"] = t.Issuer
+	}
+
+	if len(t.Directory) > 0 {
+		annotations["acme-directory"] = t.Directory
+	}
+
+	secret := &api.Secret{
 		TypeMeta: unversioned.TypeMeta{
 			Kind:       "Secret",
 			APIVersion: "v1",
@@ -72,13 +114,20 @@ func (t *DefaultTLSSecret) Secret() (*api.Secret, error) {
 			Labels: map[string]string{
 				"acme-managed": "true",
 			},
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
 			"acme.certificate-resource": crBytes,
 			"tls.crt":                   t.CertificateResource.Certificate,
 			"tls.key":                   t.CertificateResource.PrivateKey,
 		},
-	}, nil
+	}
+
+	if len(t.OCSPStaple) > 0 {
+		secret.Data[OCSPStapleKey] = t.OCSPStaple
+	}
+
+	return secret, nil
 }
 
 func TLSSecretFromSecret(secret *api.Secret) (*DefaultTLSSecret, error) {
@@ -88,10 +137,17 @@ func TLSSecretFromSecret(secret *api.Secret) (*DefaultTLSSecret, error) {
 		return nil, err
 	}
 
+	// a missing or invalid creation time is treated as unknown
+	keyCreated, _ := time.Parse(time.RFC3339, secret.Annotations["acme-key-created"])
+
 	return &DefaultTLSSecret{
 		Name:                secret.Name,
 		Namespace:           secret.Namespace,
 		CertificateResource: *cr,
+		KeyCreated:          keyCreated,
+		OCSPStaple:          secret.Data[OCSPStapleKey],
+		Issuer:              secret.Annotations["acme-issuer"],
+		Directory:           secret.Annotations["acme-directory"],
 	}, nil
 }
 
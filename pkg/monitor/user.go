@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/munnerz/kube-acme/pkg/acmeimpl"
+	"github.com/xenolf/lego/acme"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// UserSecret stores an acme account in a Kubernetes Secret, using the same
+// private.key and acme-reg.json keys that can be mounted in to /config
+type UserSecret struct {
+	Name      string
+	Namespace string
+
+	User acmeimpl.User
+}
+
+// Secret returns a complete Kubernetes Secret object for this
+// acme account
+func (u *UserSecret) Secret() (*api.Secret, error) {
+	if len(u.Name) == 0 {
+		return nil, fmt.Errorf("UserSecret name must be set")
+	}
+
+	keyBytes, err := acmeimpl.EncodePEMPrivateKey(u.User.GetPrivateKey())
+
+	if err != nil {
+		return nil, err
+	}
+
+	regBytes, err := json.Marshal(u.User.Registration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Secret{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: api.ObjectMeta{
+			Name:      u.Name,
+			Namespace: u.Namespace,
+		},
+		Data: map[string][]byte{
+			"email":         []byte(u.User.Email),
+			"private.key":   keyBytes,
+			"acme-reg.json": regBytes,
+		},
+	}, nil
+}
+
+func UserSecretFromSecret(secret *api.Secret) (*UserSecret, error) {
+	if secret.Data == nil {
+		return nil, fmt.Errorf("no acme user data found on secret")
+	}
+
+	keyBytes, ok := secret.Data["private.key"]
+
+	if !ok {
+		return nil, fmt.Errorf("no private.key found on secret")
+	}
+
+	regBytes, ok := secret.Data["acme-reg.json"]
+
+	if !ok {
+		return nil, fmt.Errorf("no acme-reg.json found on secret")
+	}
+
+	privKey, err := acmeimpl.ParsePEMPrivateKey(keyBytes)
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key: %s", err.Error())
+	}
+
+	reg := new(acme.RegistrationResource)
+
+	if err := json.Unmarshal(regBytes, reg); err != nil {
+		return nil, fmt.Errorf("error reading user registration: %s", err.Error())
+	}
+
+	return &UserSecret{
+		Name:      secret.Name,
+		Namespace: secret.Namespace,
+		User:      acmeimpl.NewUser(string(secret.Data["email"]), privKey, reg),
+	}, nil
+}
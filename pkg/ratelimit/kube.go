@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+const (
+	ledgerKey = "ledger.json"
+
+	// maxConflictRetries is the number of times an update is retried when
+	// the secret was modified by someone else
+	maxConflictRetries = 5
+)
+
+// Store persists a Ledger
+type Store interface {
+	// Load returns the stored ledger, or an empty one if it does not exist yet
+	Load() (*Ledger, error)
+	// Update loads the ledger, applies fn to it and stores the result
+	Update(fn func(*Ledger)) error
+}
+
+var _ Store = &KubeStore{}
+
+// KubeStore persists a Ledger as json in a kubernetes secret
+type KubeStore struct {
+	secrets   client.SecretsNamespacer
+	namespace string
+	name      string
+
+	lock sync.Mutex
+}
+
+func NewKubeStore(secrets client.SecretsNamespacer, namespace, name string) *KubeStore {
+	return &KubeStore{
+		secrets:   secrets,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Load returns the stored ledger, or an empty one if it does not exist yet
+func (k *KubeStore) Load() (*Ledger, error) {
+	l, _, err := k.load()
+	return l, err
+}
+
+// Update loads the ledger, applies fn to it and stores the result, retrying
+// if the secret is modified concurrently
+func (k *KubeStore) Update(fn func(*Ledger)) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		l, secret, lerr := k.load()
+
+		if lerr != nil {
+			return lerr
+		}
+
+		fn(l)
+
+		if err = k.save(l, secret); err == nil || !kerrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("error saving rate limit ledger: %s", err.Error())
+}
+
+func (k *KubeStore) load() (*Ledger, *api.Secret, error) {
+	secret, err := k.secrets.Secrets(k.namespace).Get(k.name)
+
+	if kerrors.IsNotFound(err) {
+		return &Ledger{}, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting rate limit ledger: %s", err.Error())
+	}
+
+	l := new(Ledger)
+
+	if data, ok := secret.Data[ledgerKey]; ok {
+		if err := json.Unmarshal(data, l); err != nil {
+			return nil, nil, fmt.Errorf("error decoding rate limit ledger: %s", err.Error())
+		}
+	}
+
+	return l, secret, nil
+}
+
+// save stores l in secret, or in a new secret if it is nil
+func (k *KubeStore) save(l *Ledger, secret *api.Secret) error {
+	data, err := json.Marshal(l)
+
+	if err != nil {
+		return err
+	}
+
+	if secret != nil {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[ledgerKey] = data
+		_, err = k.secrets.Secrets(k.namespace).Update(secret)
+		return err
+	}
+
+	_, err = k.secrets.Secrets(k.namespace).Create(&api.Secret{
+		TypeMeta: unversioned.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: api.ObjectMeta{
+			Name:      k.name,
+			Namespace: k.namespace,
+		},
+		Data: map[string][]byte{
+			ledgerKey: data,
+		},
+	})
+
+	// another instance created the ledger first, retry as an update
+	if kerrors.IsAlreadyExists(err) {
+		return kerrors.NewConflict(api.Resource("secrets"), k.name, err)
+	}
+
+	return err
+}
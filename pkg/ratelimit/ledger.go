@@ -0,0 +1,218 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Limits are the issuance limits of an acme server. A zero limit is not
+// enforced.
+type Limits struct {
+	// CertificatesPerDomain is the number of new certificates that may be
+	// issued per registered domain within Window. Renewals are exempt.
+	CertificatesPerDomain int
+	// DuplicateCertificates is the number of certificates that may be issued
+	// for the exact same set of hosts within Window
+	DuplicateCertificates int
+	// Window is the sliding window issuances are counted over
+	Window time.Duration
+
+	// FailedValidations is the number of failed validations allowed per host
+	// within FailedValidationWindow
+	FailedValidations int
+	// FailedValidationWindow is the sliding window failed validations are
+	// counted over
+	FailedValidationWindow time.Duration
+}
+
+// Entry is a single issuance or failed validation
+type Entry struct {
+	Issuer string    `json:"issuer"`
+	Hosts  []string  `json:"hosts"`
+	Time   time.Time `json:"time"`
+}
+
+// Failure is the state of the consecutive failed requests for a certificate
+type Failure struct {
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError"`
+	NextRetry time.Time `json:"nextRetry"`
+}
+
+// Ledger records the certificates issued and validations failed by each
+// issuer, and the failed requests for each certificate keyed by the
+// namespace/name of its secret
+type Ledger struct {
+	Issuances         []Entry            `json:"issuances"`
+	FailedValidations []Entry            `json:"failedValidations"`
+	Failures          map[string]Failure `json:"failures,omitempty"`
+}
+
+// LimitError is returned when a request would exceed a limit
+type LimitError struct {
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s, deferring until %s", e.Reason, e.RetryAt.Format(time.RFC3339))
+}
+
+// Check returns a *LimitError if issuing a certificate for hosts from issuer
+// at now would exceed limits
+func (l *Ledger) Check(issuer string, hosts []string, renewal bool, limits Limits, now time.Time) error {
+	if limits.FailedValidations > 0 {
+		for _, h := range hosts {
+			es := matching(l.FailedValidations, issuer, now.Add(-limits.FailedValidationWindow), func(e Entry) bool {
+				return contains(e.Hosts, h)
+			})
+
+			if len(es) >= limits.FailedValidations {
+				return &LimitError{
+					Reason:  fmt.Sprintf("%d failed validations for '%s' in the last %s", len(es), h, limits.FailedValidationWindow),
+					RetryAt: es[len(es)-limits.FailedValidations].Time.Add(limits.FailedValidationWindow),
+				}
+			}
+		}
+	}
+
+	if limits.DuplicateCertificates > 0 {
+		key := setKey(hosts)
+		es := matching(l.Issuances, issuer, now.Add(-limits.Window), func(e Entry) bool {
+			return setKey(e.Hosts) == key
+		})
+
+		if len(es) >= limits.DuplicateCertificates {
+			return &LimitError{
+				Reason:  fmt.Sprintf("%d certificates issued for %s in the last %s", len(es), hosts, limits.Window),
+				RetryAt: es[len(es)-limits.DuplicateCertificates].Time.Add(limits.Window),
+			}
+		}
+	}
+
+	if limits.CertificatesPerDomain > 0 && !renewal {
+		for _, d := range registeredDomains(hosts) {
+			d := d
+			es := matching(l.Issuances, issuer, now.Add(-limits.Window), func(e Entry) bool {
+				return contains(registeredDomains(e.Hosts), d)
+			})
+
+			if len(es) >= limits.CertificatesPerDomain {
+				return &LimitError{
+					Reason:  fmt.Sprintf("%d certificates issued for registered domain '%s' in the last %s", len(es), d, limits.Window),
+					RetryAt: es[len(es)-limits.CertificatesPerDomain].Time.Add(limits.Window),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// RecordIssuance records a certificate issued for hosts by issuer at now
+func (l *Ledger) RecordIssuance(issuer string, hosts []string, now time.Time) {
+	l.Issuances = append(l.Issuances, Entry{Issuer: issuer, Hosts: hosts, Time: now})
+}
+
+// RecordFailedValidation records a failed validation of hosts by issuer at
+// now
+func (l *Ledger) RecordFailedValidation(issuer string, hosts []string, now time.Time) {
+	l.FailedValidations = append(l.FailedValidations, Entry{Issuer: issuer, Hosts: hosts, Time: now})
+}
+
+// Prune removes entries that are no longer counted towards limits, and
+// failures that have not been retried within Window, such as those of
+// deleted ingresses
+func (l *Ledger) Prune(limits Limits, now time.Time) {
+	l.Issuances = after(l.Issuances, now.Add(-limits.Window))
+	l.FailedValidations = after(l.FailedValidations, now.Add(-limits.FailedValidationWindow))
+
+	for k, f := range l.Failures {
+		if f.NextRetry.Before(now.Add(-limits.Window)) {
+			delete(l.Failures, k)
+		}
+	}
+}
+
+// RegisteredDomain returns the registered domain (eTLD+1) of host, which is
+// what Let's Encrypt counts certificates per domain against
+func RegisteredDomain(host string) string {
+	host = strings.ToLower(strings.TrimPrefix(host, "*."))
+
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+
+	return host
+}
+
+func registeredDomains(hosts []string) []string {
+	var res []string
+
+	for _, h := range hosts {
+		if d := RegisteredDomain(h); !contains(res, d) {
+			res = append(res, d)
+		}
+	}
+
+	return res
+}
+
+// matching returns the entries of issuer after since that match, oldest
+// first
+func matching(es []Entry, issuer string, since time.Time, match func(Entry) bool) []Entry {
+	var res []Entry
+
+	for _, e := range es {
+		if e.Issuer == issuer && e.Time.After(since) && match(e) {
+			res = append(res, e)
+		}
+	}
+
+	sort.Sort(byTime(res))
+
+	return res
+}
+
+func after(es []Entry, since time.Time) []Entry {
+	res := []Entry{}
+
+	for _, e := range es {
+		if e.Time.After(since) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+func setKey(hosts []string) string {
+	hs := make([]string, len(hosts))
+
+	for i, h := range hosts {
+		hs[i] = strings.ToLower(h)
+	}
+
+	sort.Strings(hs)
+
+	return strings.Join(hs, ",")
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+type byTime []Entry
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
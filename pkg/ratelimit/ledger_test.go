@@ -0,0 +1,226 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+
+var testLimits = Limits{
+	CertificatesPerDomain:  2,
+	DuplicateCertificates:  2,
+	Window:                 time.Hour * 24 * 7,
+	FailedValidations:      2,
+	FailedValidationWindow: time.Hour,
+}
+
+func entry(issuer string, age time.Duration, hosts ...string) Entry {
+	return Entry{Issuer: issuer, Hosts: hosts, Time: testNow.Add(-age)}
+}
+
+func TestCheck(t *testing.T) {
+	day := time.Hour * 24
+
+	tests := []struct {
+		name    string
+		ledger  Ledger
+		hosts   []string
+		renewal bool
+
+		// retryAt is the expected LimitError retry time, or zero for no
+		// error
+		retryAt time.Time
+	}{
+		{
+			name:  "empty",
+			hosts: []string{"example.com"},
+		},
+		{
+			name: "per domain",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*2, "a.example.com"),
+				entry("default", day, "b.example.com"),
+			}},
+			hosts:   []string{"c.example.com"},
+			retryAt: testNow.Add(-day * 2).Add(testLimits.Window),
+		},
+		{
+			name: "per domain counts the registered domain",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*2, "a.example.co.uk"),
+				entry("default", day, "b.example.co.uk"),
+			}},
+			hosts: []string{"other.co.uk"},
+		},
+		{
+			name: "per domain wildcard",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*2, "*.example.com"),
+				entry("default", day, "www.EXAMPLE.com"),
+			}},
+			hosts:   []string{"example.com"},
+			retryAt: testNow.Add(-day * 2).Add(testLimits.Window),
+		},
+		{
+			name: "per domain renewal exempt",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*2, "a.example.com"),
+				entry("default", day, "b.example.com"),
+			}},
+			hosts:   []string{"c.example.com"},
+			renewal: true,
+		},
+		{
+			name: "per domain outside window",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*8, "a.example.com"),
+				entry("default", day, "b.example.com"),
+			}},
+			hosts: []string{"c.example.com"},
+		},
+		{
+			name: "per domain other issuer",
+			ledger: Ledger{Issuances: []Entry{
+				entry("other", day*2, "a.example.com"),
+				entry("default", day, "b.example.com"),
+			}},
+			hosts: []string{"c.example.com"},
+		},
+		{
+			name: "duplicate set",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*3, "www.example.com", "example.com"),
+				entry("default", day, "example.com", "www.example.com"),
+			}},
+			hosts:   []string{"example.com", "www.example.com"},
+			renewal: true,
+			retryAt: testNow.Add(-day * 3).Add(testLimits.Window),
+		},
+		{
+			name: "duplicate subset",
+			ledger: Ledger{Issuances: []Entry{
+				entry("default", day*3, "www.example.com", "example.com"),
+				entry("default", day, "example.com", "www.example.com"),
+			}},
+			hosts:   []string{"example.com"},
+			renewal: true,
+		},
+		{
+			name: "failed validations",
+			ledger: Ledger{FailedValidations: []Entry{
+				entry("default", time.Minute*30, "example.com"),
+				entry("default", time.Minute*10, "example.com"),
+			}},
+			hosts:   []string{"www.example.com", "example.com"},
+			renewal: true,
+			retryAt: testNow.Add(time.Minute * 30),
+		},
+		{
+			name: "failed validations outside window",
+			ledger: Ledger{FailedValidations: []Entry{
+				entry("default", time.Minute*90, "example.com"),
+				entry("default", time.Minute*10, "example.com"),
+			}},
+			hosts: []string{"example.com"},
+		},
+		{
+			name: "failed validations other host",
+			ledger: Ledger{FailedValidations: []Entry{
+				entry("default", time.Minute*30, "www.example.com"),
+				entry("default", time.Minute*10, "www.example.com"),
+			}},
+			hosts: []string{"example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.ledger.Check("default", test.hosts, test.renewal, testLimits, testNow)
+
+		if test.retryAt.IsZero() {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			}
+			continue
+		}
+
+		limitErr, ok := err.(*LimitError)
+
+		if !ok {
+			t.Errorf("%s: expected a limit error, got: %v", test.name, err)
+			continue
+		}
+
+		if !limitErr.RetryAt.Equal(test.retryAt) {
+			t.Errorf("%s: expected retry at %s, got %s", test.name, test.retryAt, limitErr.RetryAt)
+		}
+	}
+}
+
+func TestCheckDisabledLimits(t *testing.T) {
+	l := Ledger{
+		Issuances:         []Entry{entry("default", time.Hour, "example.com")},
+		FailedValidations: []Entry{entry("default", time.Minute, "example.com")},
+	}
+
+	if err := l.Check("default", []string{"example.com"}, false, Limits{}, testNow); err != nil {
+		t.Errorf("expected zero limits not to be enforced, got: %s", err.Error())
+	}
+}
+
+func TestPrune(t *testing.T) {
+	day := time.Hour * 24
+
+	l := Ledger{
+		Issuances: []Entry{
+			entry("default", day*8, "old.example.com"),
+			entry("default", day, "new.example.com"),
+		},
+		FailedValidations: []Entry{
+			entry("default", time.Minute*90, "old.example.com"),
+			entry("default", time.Minute*10, "new.example.com"),
+		},
+		Failures: map[string]Failure{
+			"default/old-tls":     {Failures: 3, NextRetry: testNow.Add(-day * 8)},
+			"default/pending-tls": {Failures: 1, NextRetry: testNow.Add(-day)},
+			"default/waiting-tls": {Failures: 5, NextRetry: testNow.Add(day)},
+		},
+	}
+
+	l.Prune(testLimits, testNow)
+
+	if len(l.Issuances) != 1 || l.Issuances[0].Hosts[0] != "new.example.com" {
+		t.Errorf("expected only the issuance within the window to remain, got %v", l.Issuances)
+	}
+
+	if len(l.FailedValidations) != 1 || l.FailedValidations[0].Hosts[0] != "new.example.com" {
+		t.Errorf("expected only the failed validation within the window to remain, got %v", l.FailedValidations)
+	}
+
+	for _, k := range []string{"default/pending-tls", "default/waiting-tls"} {
+		if _, ok := l.Failures[k]; !ok {
+			t.Errorf("expected failure %s to remain", k)
+		}
+	}
+
+	if _, ok := l.Failures["default/old-tls"]; ok {
+		t.Errorf("expected failure not retried within the window to be pruned")
+	}
+}
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":       "example.com",
+		"www.EXAMPLE.com":   "example.com",
+		"*.example.com":     "example.com",
+		"a.b.example.co.uk": "example.co.uk",
+		"foo.bar.github.io": "bar.github.io",
+		"localhost":         "localhost",
+	}
+
+	for host, expected := range tests {
+		if d := RegisteredDomain(host); d != expected {
+			t.Errorf("expected registered domain of '%s' to be '%s', got '%s'", host, expected, d)
+		}
+	}
+}
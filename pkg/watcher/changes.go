@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"reflect"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// Change is a set of the kinds of change between two versions of an ingress
+type Change uint
+
+const (
+	// ChangeTLS is a change to the tls hosts or secrets in the spec
+	ChangeTLS Change = 1 << iota
+	// ChangeLoadBalancer is a load balancer address being assigned or changed
+	// in the status
+	ChangeLoadBalancer
+	// ChangeLabels is a change to the labels
+	ChangeLabels
+	// ChangeAnnotations is a change to the acme- annotations
+	ChangeAnnotations
+
+	// ChangeNone is no change that may affect certificates, as seen on a
+	// resync or a change to the rules, other annotations or the resource
+	// version
+	ChangeNone Change = 0
+)
+
+var changeNames = []struct {
+	change Change
+	name   string
+}{
+	{ChangeTLS, "tls"},
+	{ChangeLoadBalancer, "loadBalancer"},
+	{ChangeLabels, "labels"},
+	{ChangeAnnotations, "annotations"},
+}
+
+// Relevant returns true if c may affect the certificates of the ingress
+func (c Change) Relevant() bool {
+	return c != ChangeNone
+}
+
+func (c Change) String() string {
+	var names []string
+
+	for _, n := range changeNames {
+		if c&n.change != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// ClassifyIngressChange returns the kinds of change from old to cur
+func ClassifyIngressChange(old, cur *extensions.Ingress) Change {
+	var c Change
+
+	if !reflect.DeepEqual(old.Spec.TLS, cur.Spec.TLS) {
+		c |= ChangeTLS
+	}
+
+	if !reflect.DeepEqual(old.Status.LoadBalancer, cur.Status.LoadBalancer) && len(cur.Status.LoadBalancer.Ingress) > 0 {
+		c |= ChangeLoadBalancer
+	}
+
+	if !reflect.DeepEqual(old.Labels, cur.Labels) {
+		c |= ChangeLabels
+	}
+
+	if !reflect.DeepEqual(acmeAnnotations(old), acmeAnnotations(cur)) {
+		c |= ChangeAnnotations
+	}
+
+	return c
+}
+
+func acmeAnnotations(ing *extensions.Ingress) map[string]string {
+	res := map[string]string{}
+
+	for k, v := range ing.Annotations {
+		if strings.HasPrefix(k, "acme-") {
+			res[k] = v
+		}
+	}
+
+	return res
+}
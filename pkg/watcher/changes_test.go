@@ -0,0 +1,95 @@
+package watcher
+
+import (
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func testIngress() *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:            "example",
+			Namespace:       "default",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"acme-tls": "true"},
+			Annotations:     map[string]string{"acme-key-type": "P256", "other": "value"},
+		},
+		Spec: extensions.IngressSpec{
+			TLS: []extensions.IngressTLS{
+				{Hosts: []string{"example.com"}, SecretName: "example-tls"},
+			},
+		},
+	}
+}
+
+func TestClassifyIngressChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(*extensions.Ingress)
+		expected Change
+	}{
+		{"resync", func(ing *extensions.Ingress) {}, ChangeNone},
+		{"tls hosts", func(ing *extensions.Ingress) {
+			ing.Spec.TLS[0].Hosts = append(ing.Spec.TLS[0].Hosts, "www.example.com")
+		}, ChangeTLS},
+		{"tls secret", func(ing *extensions.Ingress) {
+			ing.Spec.TLS[0].SecretName = "other-tls"
+		}, ChangeTLS},
+		{"load balancer assigned", func(ing *extensions.Ingress) {
+			ing.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		}, ChangeLoadBalancer},
+		{"label", func(ing *extensions.Ingress) {
+			ing.Labels["acme-tls"] = "false"
+		}, ChangeLabels},
+		{"acme annotation", func(ing *extensions.Ingress) {
+			ing.Annotations["acme-key-type"] = "P384"
+		}, ChangeAnnotations},
+		{"acme annotation added", func(ing *extensions.Ingress) {
+			ing.Annotations["acme-retry-now"] = "true"
+		}, ChangeAnnotations},
+		{"tls and labels", func(ing *extensions.Ingress) {
+			ing.Spec.TLS = nil
+			ing.Labels = nil
+		}, ChangeTLS | ChangeLabels},
+		{"unrelated annotation", func(ing *extensions.Ingress) {
+			ing.Annotations["other"] = "changed"
+		}, ChangeNone},
+		{"rules", func(ing *extensions.Ingress) {
+			ing.Spec.Rules = []extensions.IngressRule{{Host: "example.com"}}
+		}, ChangeNone},
+		{"resource version", func(ing *extensions.Ingress) {
+			ing.ResourceVersion = "2"
+		}, ChangeNone},
+	}
+
+	for _, test := range tests {
+		old, cur := testIngress(), testIngress()
+		test.change(cur)
+
+		if c := ClassifyIngressChange(old, cur); c != test.expected {
+			t.Errorf("%s: expected change %s, got %s", test.name, test.expected, c)
+		}
+
+		if c := ClassifyIngressChange(old, cur); c.Relevant() != (test.expected != ChangeNone) {
+			t.Errorf("%s: expected relevant %t, got %t", test.name, test.expected != ChangeNone, c.Relevant())
+		}
+	}
+}
+
+func TestClassifyIngressStatusOnlyChange(t *testing.T) {
+	old, cur := testIngress(), testIngress()
+	old.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: "10.0.0.1"}}
+
+	// the load balancer address being removed is not a signal to issue
+	if c := ClassifyIngressChange(old, cur); c != ChangeNone {
+		t.Errorf("expected a removed load balancer address to be %s, got %s", ChangeNone, c)
+	}
+
+	cur.Status.LoadBalancer.Ingress = []api.LoadBalancerIngress{{IP: "10.0.0.2"}}
+
+	if c := ClassifyIngressChange(old, cur); c != ChangeLoadBalancer {
+		t.Errorf("expected a changed load balancer address to be %s, got %s", ChangeLoadBalancer, c)
+	}
+}
@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/controller/framework"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// WatchSecrets calls the functions in c for changes to the secrets matching
+// selector, so that only the secrets that are needed are held in memory
+func (w *Watcher) WatchSecrets(ctx context.Context, resyncPeriod time.Duration, selector labels.Selector, c ChangeFuncs) {
+	secretHandlers := framework.ResourceEventHandlerFuncs{
+		AddFunc:    c.AddFunc,
+		DeleteFunc: c.DeleteFunc,
+		UpdateFunc: c.UpdateFunc,
+	}
+
+	_, ctrl := framework.NewInformer(
+		&cache.ListWatch{
+			ListFunc:  secretListFunc(w.kubeClient, w.namespace, selector),
+			WatchFunc: secretWatchFunc(w.kubeClient, w.namespace, selector),
+		},
+		&api.Secret{}, resyncPeriod, secretHandlers)
+
+	ctrl.Run(ctx.Done())
+}
+
+func secretListFunc(c *client.Client, ns string, selector labels.Selector) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		opts.LabelSelector = selector
+		return c.Secrets(ns).List(opts)
+	}
+}
+
+func secretWatchFunc(c *client.Client, ns string, selector labels.Selector) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		options.LabelSelector = selector
+		return c.Secrets(ns).Watch(options)
+	}
+}
@@ -2,8 +2,11 @@ package watcher
 
 import client "k8s.io/kubernetes/pkg/client/unversioned"
 
+// New returns a Watcher for resources in namespace, or in all namespaces if
+// namespace is api.NamespaceAll
 func New(client *client.Client, namespace string) (*Watcher, error) {
 	return &Watcher{
 		kubeClient: client,
+		namespace:  namespace,
 	}, nil
 }